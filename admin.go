@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+// createAdmin gives email the admin role, creating the account with a
+// password read from the first line of password if it does not exist. It
+// backs the -create-admin flag, for setting up the first admin.
+func (cfg *apiConfig) createAdmin(ctx context.Context, email string, password io.Reader) (database.User, error) {
+	dbUser, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if err == nil {
+		return cfg.dbQueries.UpdateUserRole(ctx, database.UpdateUserRoleParams{
+			Role: auth.RoleAdmin,
+			ID:   dbUser.ID,
+		})
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if !validEmail(email) {
+		return database.User{}, fmt.Errorf("invalid email address %q", email)
+	}
+
+	line, err := bufio.NewReader(password).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return database.User{}, fmt.Errorf("reading password: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if violations := cfg.passwordPolicy.Check(line); len(violations) > 0 {
+		return database.User{}, fmt.Errorf("password does not meet requirements: %s", violations[0].Message)
+	}
+
+	hashed, err := auth.HashPassword(line)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	dbUser, err = qtx.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashed,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	dbUser, err = qtx.UpdateUserRole(ctx, database.UpdateUserRoleParams{
+		Role: auth.RoleAdmin,
+		ID:   dbUser.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return dbUser, tx.Commit()
+}
+
+// promoteAdmins gives the admin role to each of emails that has an
+// account. ADMIN_EMAILS used to grant admin on every login; it is now only
+// applied at startup, so existing deployments keep their admins.
+func (cfg *apiConfig) promoteAdmins(ctx context.Context, emails []string) error {
+	for _, email := range emails {
+		n, err := cfg.dbQueries.SetUserRoleByEmail(ctx, database.SetUserRoleByEmailParams{
+			Role:  auth.RoleAdmin,
+			Email: strings.TrimSpace(email),
+		})
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			log.Printf("ADMIN_EMAILS: no account for %s", email)
+		}
+	}
+
+	return nil
+}
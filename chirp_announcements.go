@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/w0/chirpy/internal/database"
+)
+
+const (
+	chirpAnnouncePeriod = 30 * time.Second
+	chirpAnnounceBatch  = 100
+)
+
+// announceChirp sends chirp.created for a chirp that is already live. The
+// chirp is marked announced in the same transaction, so it goes out once
+// even if announceScheduledChirps gets to it first. Chirps left
+// unannounced by an error here are picked up by that job.
+func (cfg *apiConfig) announceChirp(ctx context.Context, chirp database.Chirp) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	n, err := qtx.MarkChirpAnnounced(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	err = emitWebhookEvent(ctx, qtx, chirp.UserID, eventChirpCreated, chirpFromDB(chirp))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// announceScheduledChirps sends chirp.created for chirps whose publish time
+// has come, a batch at a time until none are left.
+func (cfg *apiConfig) announceScheduledChirps(ctx context.Context) error {
+	for {
+		n, err := cfg.announceChirpBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n < chirpAnnounceBatch {
+			return nil
+		}
+	}
+}
+
+func (cfg *apiConfig) announceChirpBatch(ctx context.Context) (int, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirps, err := qtx.ClaimUnannouncedChirps(ctx, chirpAnnounceBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, chirp := range chirps {
+		err = emitWebhookEvent(ctx, qtx, chirp.UserID, eventChirpCreated, chirpFromDB(chirp))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(chirps), tx.Commit()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/contentfilter"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+// contentFilterReloadPeriod bounds how long a rule changed through another
+// instance takes to apply here. Changes made through this one apply at
+// once.
+const contentFilterReloadPeriod = time.Minute
+
+type ContentFilterRule struct {
+	Id        uuid.UUID            `json:"id"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Pattern   string               `json:"pattern"`
+	Action    contentfilter.Action `json:"action"`
+	WholeWord bool                 `json:"whole_word"`
+	Leet      bool                 `json:"leet"`
+}
+
+func contentFilterRuleFromDB(r database.ContentFilterRule) ContentFilterRule {
+	return ContentFilterRule{
+		Id:        r.ID,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+		Pattern:   r.Pattern,
+		Action:    contentfilter.Action(r.Action),
+		WholeWord: r.WholeWord,
+		Leet:      r.Leet,
+	}
+}
+
+// reloadContentFilter swaps in the configured rules plus those in the
+// database. Chirps being filtered meanwhile use the old rules.
+func (cfg *apiConfig) reloadContentFilter(ctx context.Context) error {
+	dbRules, err := cfg.dbQueries.ListContentFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	rules := append([]contentfilter.Rule{}, cfg.contentFilterRules...)
+	for _, r := range dbRules {
+		rules = append(rules, contentfilter.Rule{
+			ID:        r.ID.String(),
+			Pattern:   r.Pattern,
+			Action:    contentfilter.Action(r.Action),
+			WholeWord: r.WholeWord,
+			Leet:      r.Leet,
+		})
+	}
+
+	return cfg.contentFilter.Load(rules)
+}
+
+// flagChirp puts a chirp that matched a flag rule in the moderator queue.
+func (cfg *apiConfig) flagChirp(ctx context.Context, chirp database.Chirp, result contentfilter.Result) error {
+	matched := []string{}
+	for _, m := range result.Matches {
+		if m.Rule.Action == contentfilter.Flag {
+			matched = append(matched, m.Rule.Pattern)
+		}
+	}
+
+	_, err := cfg.dbQueries.CreateReport(ctx, database.CreateReportParams{
+		UserID:  chirp.UserID,
+		ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		Reason:  moderation.ReasonFilter,
+		Details: "matched " + strings.Join(matched, ", "),
+	})
+	// Flagged again after an edit, while the first report is still open.
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
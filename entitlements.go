@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/entitlements"
+)
+
+// userPlan looks up userID along with what their plan lets them do.
+func (cfg *apiConfig) userPlan(ctx context.Context, userID uuid.UUID) (database.User, entitlements.Plan, error) {
+	dbUser, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, entitlements.Plan{}, err
+	}
+
+	return dbUser, entitlements.ForUser(dbUser.IsChirpyRed), nil
+}
+
+func respondWithEntitlementError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, entitlements.ErrNotEntitled):
+		respondWithError(w, http.StatusForbidden, "this feature requires Chirpy Red", err)
+	case errors.Is(err, entitlements.ErrChirpTooLong):
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", err)
+	default:
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+	}
+}
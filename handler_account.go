@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+// handlerDeleteUser schedules the caller's account for deletion once the
+// grace period runs out. Logging in before then cancels it.
+func (cfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type deleteUser struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var d deleteUser
+	err := decoder.Decode(&d)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	_, err = auth.CheckPasswordHash(d.Password, dbUser.HashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "password is incorrect", nil)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		ok, err := cfg.checkSecondFactor(req.Context(), dbUser, d.Code)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to check code", err)
+			return
+		}
+
+		if !ok {
+			respondWithError(w, http.StatusForbidden, "invalid code", nil)
+			return
+		}
+	}
+
+	deleteAt := time.Now().Add(cfg.deletionGracePeriod)
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to start transaction", err)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.ScheduleUserDeletion(req.Context(), database.ScheduleUserDeletionParams{
+		DeletionScheduledAt: sql.NullTime{Time: deleteAt, Valid: true},
+		ID:                  dbUser.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to schedule deletion", err)
+		return
+	}
+
+	err = qtx.RevokeUserRefreshTokens(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke sessions", err)
+		return
+	}
+
+	err = qtx.RevokeUserPersonalAccessTokens(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke tokens", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to commit", err)
+		return
+	}
+
+	type deletionScheduled struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+
+	respondWithJSON(w, http.StatusAccepted, deletionScheduled{
+		DeletionScheduledAt: deleteAt,
+	})
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/w0/chirpy/internal/auth"
 	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 	type userLogin struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string   `json:"email"`
+		Password string   `json:"password"`
+		Scopes   []string `json:"scopes"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -26,20 +31,105 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	ip := clientIP(req)
+
+	if retryAfter, blocked := cfg.loginThrottle.blocked(login.Email, ip); blocked {
+		respondWithTooManyAttempts(w, retryAfter)
+		return
+	}
+
 	dbUser, err := cfg.dbQueries.GetUserByEmail(req.Context(), login.Email)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.DummyCheckPassword(login.Password)
+		cfg.loginThrottle.fail(login.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "invalid credentials", nil)
+		return
+	}
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up user", err)
+		return
+	}
+
+	needsRehash, err := auth.CheckPasswordHash(login.Password, dbUser.HashedPassword)
+
+	if err != nil {
+		cfg.loginThrottle.fail(login.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "invalid credentials", nil)
+		return
+	}
+
+	if needsRehash {
+		cfg.rehashPassword(req.Context(), dbUser, login.Password)
+	}
+
+	// Checked only once the password is right, so guessing it does not
+	// reveal whether the account is suspended.
+	err = accountFromDB(dbUser).Check(time.Now())
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "User not found", err)
+		respondWithAccountRestricted(w, err)
+		return
+	}
+
+	scopes, ok := auth.NarrowScopes(cfg.allowedScopes(dbUser), login.Scopes)
+
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid scope requested", nil)
+		return
 	}
 
-	err = auth.CheckPasswordHash(login.Password, dbUser.HashedPassword)
+	// The backoff is only cleared once the second factor is passed too,
+	// by handlerLoginTwoFactor, or a correct password would buy a fresh
+	// round of code guesses.
+	if dbUser.TotpEnabled {
+		cfg.respondWithTwoFactorChallenge(w, dbUser)
+		return
+	}
+
+	cfg.loginThrottle.succeed(login.Email)
+	cfg.issueSession(w, req, dbUser, scopes)
+}
 
+// rehashPassword moves a user onto the current password hash format. It
+// only runs after a successful login, the one time the plain password is
+// known. Failure is logged rather than failing the login.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, dbUser database.User, password string) {
+	hashed, err := auth.HashPassword(password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid password", err)
+		log.Printf("failed to rehash password for %s: %s", dbUser.ID, err)
 		return
 	}
 
-	jwt, err := auth.MakeJWT(dbUser.ID, cfg.secret, time.Hour*1)
+	err = cfg.dbQueries.UpdatePassword(ctx, database.UpdatePasswordParams{
+		HashedPassword: hashed,
+		ID:             dbUser.ID,
+	})
+	if err != nil {
+		log.Printf("failed to store rehashed password for %s: %s", dbUser.ID, err)
+	}
+}
+
+// issueSession responds with a new access token and refresh token pair for
+// user. Every successful login path ends here.
+func (cfg *apiConfig) issueSession(w http.ResponseWriter, req *http.Request, dbUser database.User, scopes []string) {
+	err := accountFromDB(dbUser).Check(time.Now())
+	if err != nil {
+		respondWithAccountRestricted(w, err)
+		return
+	}
+
+	// Signing back in during the grace period keeps the account.
+	if dbUser.DeletionScheduledAt.Valid {
+		err := cfg.dbQueries.CancelUserDeletion(req.Context(), dbUser.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to cancel account deletion", err)
+			return
+		}
+		dbUser.DeletionScheduledAt = sql.NullTime{}
+	}
+
+	jwt, err := auth.MakeJWT(dbUser.ID, cfg.secret, time.Hour*1, cfg.sessionJWTOptions(dbUser, scopes)...)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to create jwt", err)
@@ -57,6 +147,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		Token:     refreshToken,
 		UserID:    dbUser.ID,
 		ExpiresAt: time.Now().AddDate(0, 0, 60),
+		Scope:     auth.FormatScopes(scopes),
 	})
 
 	if err != nil {
@@ -65,23 +156,15 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 	}
 
 	type userToken struct {
-		Id           uuid.UUID `json:"id"`
-		Email        string    `json:"email"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Token        string    `json:"token"`
-		RefreshToken string    `json:"refresh_token"`
-		IsChirpyRed  bool      `json:"is_chirpy_red"`
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	respondWithJSON(w, http.StatusOK, userToken{
-		Id:           dbUser.ID,
-		Email:        dbUser.Email,
-		CreatedAt:    dbUser.CreatedAt,
-		UpdatedAt:    dbUser.UpdatedAt,
+		User:         userFromDB(dbUser),
 		Token:        jwt,
 		RefreshToken: dbRefreshToken.Token,
-		IsChirpyRed:  dbUser.IsChirpyRed,
 	})
 }
 
@@ -89,6 +172,7 @@ func (cfg *apiConfig) handlerRefreshJWT(w http.ResponseWriter, req *http.Request
 	bearerToken, err := auth.GetBearerToken(req.Header)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "refresh token not found", err)
+		return
 	}
 
 	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(req.Context(), bearerToken)
@@ -108,7 +192,30 @@ func (cfg *apiConfig) handlerRefreshJWT(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	jwt, err := auth.MakeJWT(dbRefreshToken.UserID, cfg.secret, time.Hour*1)
+	// Tokens issued to third-party apps are refreshed at /oauth/token,
+	// where the app has to authenticate.
+	if dbRefreshToken.ClientID.Valid {
+		respondWithError(w, http.StatusUnauthorized, "token belongs to an oauth client", nil)
+		return
+	}
+
+	// The role is read again rather than kept with the refresh token, so a
+	// change takes effect within one access token lifetime.
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), dbRefreshToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up user", err)
+		return
+	}
+
+	err = accountFromDB(dbUser).Check(time.Now())
+	if err != nil {
+		respondWithAccountRestricted(w, err)
+		return
+	}
+
+	scopes := auth.ParseScopes(dbRefreshToken.Scope)
+
+	jwt, err := auth.MakeJWT(dbUser.ID, cfg.secret, time.Hour*1, cfg.sessionJWTOptions(dbUser, scopes)...)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "create token failed", err)
 		return
@@ -155,3 +262,79 @@ func (cfg *apiConfig) handlerRevokeRefreshToken(w http.ResponseWriter, req *http
 
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
+
+func (cfg *apiConfig) jwtOptions(scopes ...string) []auth.JWTOption {
+	return []auth.JWTOption{
+		auth.WithIssuer(cfg.jwtIssuer),
+		auth.WithAudience(cfg.jwtAudience),
+		auth.WithLeeway(cfg.jwtLeeway),
+		auth.WithScopes(scopes...),
+	}
+}
+
+// sessionJWTOptions is jwtOptions for a first party session, which also
+// carries the user's role. Tokens for third party apps never do.
+func (cfg *apiConfig) sessionJWTOptions(user database.User, scopes []string) []auth.JWTOption {
+	return append(cfg.jwtOptions(scopes...), auth.WithRole(user.Role))
+}
+
+// allowedScopes is the widest set of scopes a token for user may carry.
+func (cfg *apiConfig) allowedScopes(user database.User) []string {
+	return auth.RoleScopes(user.Role)
+}
+
+// respondWithAccountRestricted tells a suspended or banned user why they
+// cannot sign in, and until when.
+func respondWithAccountRestricted(w http.ResponseWriter, err error) {
+	type restrictedResponse struct {
+		Error          string     `json:"error"`
+		Reason         string     `json:"reason,omitempty"`
+		SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	}
+
+	resp := restrictedResponse{Error: err.Error()}
+
+	var r *moderation.Restriction
+	if errors.As(err, &r) {
+		resp.Reason = r.Reason
+		if !r.Until.IsZero() {
+			resp.SuspendedUntil = &r.Until
+		}
+	}
+
+	respondWithJSON(w, http.StatusForbidden, resp)
+}
+
+// respondWithAuthError turns a failed GetBearerToken or ValidateJWT into a 401 that tells the
+// client why the token was rejected.
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	msg := "invalid token"
+
+	switch {
+	case errors.Is(err, auth.ErrNoToken):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondWithError(w, http.StatusUnauthorized, "authorization missing", err)
+		return
+	case errors.Is(err, auth.ErrExpired):
+		msg = "token expired"
+	case errors.Is(err, auth.ErrNotYetValid):
+		msg = "token not valid yet"
+	case errors.Is(err, auth.ErrBadSignature):
+		msg = "invalid token signature"
+	case errors.Is(err, auth.ErrInvalidIssuer):
+		msg = "invalid token issuer"
+	case errors.Is(err, auth.ErrInvalidAudience):
+		msg = "invalid token audience"
+	case errors.Is(err, auth.ErrMalformedSubject):
+		msg = "invalid token subject"
+	case errors.Is(err, auth.ErrMalformedToken):
+		msg = "malformed token"
+	case errors.Is(err, auth.ErrUnknownToken):
+		msg = "unknown token"
+	case errors.Is(err, auth.ErrRevoked):
+		msg = "token revoked"
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, msg))
+	respondWithError(w, http.StatusUnauthorized, msg, err)
+}
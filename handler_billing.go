@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/billing"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/webhook"
+)
+
+const maxWebhookBody = 1 << 20
+
+var (
+	errUnknownBillingCustomer = errors.New("unknown billing customer")
+	errUnknownBillingUser     = errors.New("unknown billing user")
+)
+
+// eventForgetter is implemented by providers that remember event IDs to
+// reject replays, so an event that failed to process can be retried.
+type eventForgetter interface {
+	Forget(eventID string)
+}
+
+func forgetBillingEvent(provider billing.Provider, eventID string) {
+	if f, ok := provider.(eventForgetter); ok {
+		f.Forget(eventID)
+	}
+}
+
+// billingUser works out which user a notification is about. Providers that
+// name the user as well as their own customer have the pair remembered, so
+// later notifications naming only the customer can be matched up.
+func (cfg *apiConfig) billingUser(ctx context.Context, provider string, n billing.Notification) (uuid.UUID, error) {
+	if n.UserID != uuid.Nil {
+		_, err := cfg.dbQueries.GetUserByID(ctx, n.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, fmt.Errorf("%w: %s", errUnknownBillingUser, n.UserID)
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		if n.CustomerID != "" {
+			err = cfg.dbQueries.UpsertBillingCustomer(ctx, database.UpsertBillingCustomerParams{
+				Provider:   provider,
+				CustomerID: n.CustomerID,
+				UserID:     n.UserID,
+			})
+			if err != nil {
+				return uuid.Nil, err
+			}
+		}
+		return n.UserID, nil
+	}
+
+	customer, err := cfg.dbQueries.GetBillingCustomer(ctx, database.GetBillingCustomerParams{
+		Provider:   provider,
+		CustomerID: n.CustomerID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("%w: %s %s", errUnknownBillingCustomer, provider, n.CustomerID)
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return customer.UserID, nil
+}
+
+// processBillingEvent applies a stored provider event. It reports whether
+// the event was one we ignore.
+func (cfg *apiConfig) processBillingEvent(ctx context.Context, provider billing.Provider, payload []byte) (bool, error) {
+	n, err := provider.Parse(payload)
+	if err != nil {
+		return false, err
+	}
+
+	if n.Ignored() {
+		return true, nil
+	}
+
+	userID, err := cfg.billingUser(ctx, provider.Name(), n)
+	if errors.Is(err, errUnknownBillingUser) {
+		// The account may have been deleted since it subscribed. Retrying
+		// will not bring it back.
+		log.Printf("ignoring %s %s: %s", provider.Name(), n.Type, err)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = cfg.applySubscriptionEvent(ctx, userID, n.Event)
+	if errors.Is(err, billing.ErrInvalidTransition) {
+		// Providers can send events out of order, such as a cancellation
+		// after a downgrade. There is nothing to do, and retrying will not
+		// help.
+		log.Printf("ignoring %s %s for user %s: %s", provider.Name(), n.Type, userID, err)
+		return true, nil
+	}
+
+	return false, err
+}
+
+// handlerPolkaWebhook keeps the URL Polka was first set up with working.
+func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, req *http.Request) {
+	req.SetPathValue("provider", "polka")
+	cfg.handlerBillingWebhook(w, req)
+}
+
+func (cfg *apiConfig) handlerBillingWebhook(w http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.billingProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "unknown billing provider", nil)
+		return
+	}
+
+	// The signature covers the exact bytes sent, so read them before
+	// decoding.
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxWebhookBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "failed reading body", err)
+		return
+	}
+
+	eventID, err := provider.Verify(req.Header, body)
+	// A retry of something we have already handled is acknowledged, so the
+	// provider stops sending it.
+	if errors.Is(err, webhook.ErrReplayed) {
+		respondWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+	if errors.Is(err, billing.ErrInvalidNotification) {
+		respondWithError(w, http.StatusBadRequest, "invalid event", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid signature", err)
+		return
+	}
+
+	n, err := provider.Parse(body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid event", err)
+		return
+	}
+
+	// Unsigned deliveries carry no ID of their own, so a resend of the same
+	// event is recognised by its content instead.
+	if eventID == "" {
+		eventID = billing.UnsignedEventID(n, body, time.Now())
+	}
+
+	event, claimed, err := cfg.claimWebhookEvent(req.Context(), provider.Name(), eventID, n.Type, body)
+	if err != nil {
+		forgetBillingEvent(provider, eventID)
+		respondWithError(w, http.StatusInternalServerError, "failed recording event", err)
+		return
+	}
+
+	if !claimed {
+		respondWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	err = cfg.processWebhookEvent(req.Context(), event)
+	if err != nil {
+		// Let the provider's retry through rather than rejecting it as a
+		// replay.
+		forgetBillingEvent(provider, eventID)
+		respondWithError(w, http.StatusInternalServerError, "failed processing event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
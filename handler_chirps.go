@@ -1,42 +1,99 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
-	"regexp"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/w0/chirpy/internal/auth"
 	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/entitlements"
 )
 
 type Chirp struct {
-	Id        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserId    uuid.UUID `json:"user_id"`
+	Id          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	PublishedAt time.Time `json:"published_at"`
+	Body        string    `json:"body"`
+	UserId      uuid.UUID `json:"user_id"`
+	// Moderation is only set on hidden chirps, which only their author
+	// can see.
+	Moderation *ChirpModeration `json:"moderation,omitempty"`
 }
 
-func (cfg *apiConfig) handlerNewChirp(w http.ResponseWriter, req *http.Request) {
+type ChirpModeration struct {
+	HiddenAt time.Time `json:"hidden_at"`
+	Reason   string    `json:"reason"`
+	Notice   string    `json:"notice"`
+}
+
+func chirpFromDB(c database.Chirp) Chirp {
+	chirp := Chirp{
+		Id:          c.ID,
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+		PublishedAt: c.PublishedAt,
+		Body:        c.Body,
+		UserId:      c.UserID,
+	}
 
-	bearerToken, err := auth.GetBearerToken(req.Header)
+	if c.HiddenAt.Valid {
+		chirp.Moderation = &ChirpModeration{
+			HiddenAt: c.HiddenAt.Time,
+			Reason:   c.HiddenReason.String,
+			Notice:   "This chirp was hidden by a moderator and is only visible to you.",
+		}
+	}
+
+	return chirp
+}
+
+// chirpVisibleTo reports whether viewer, who may be anonymous, can see c.
+// Scheduled chirps are hidden from everyone until published. Chirps hidden
+// by a moderator, or written by a shadow banned user, are hidden from
+// everyone but their author.
+func (cfg *apiConfig) chirpVisibleTo(ctx context.Context, c database.Chirp, viewer uuid.NullUUID) (bool, error) {
+	if c.PublishedAt.After(time.Now()) {
+		return false, nil
+	}
+
+	if viewer.Valid && viewer.UUID == c.UserID {
+		return true, nil
+	}
+
+	if c.HiddenAt.Valid {
+		return false, nil
+	}
 
+	author, err := cfg.dbQueries.GetUserByID(ctx, c.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "missing Authorization header", err)
-		return
+		return false, err
 	}
 
-	userID, err := auth.ValidateJWT(bearerToken, cfg.secret)
+	return !author.ShadowBannedAt.Valid, nil
+}
+
+func (cfg *apiConfig) handlerNewChirp(w http.ResponseWriter, req *http.Request) {
+	userID := principalFromContext(req.Context()).UserID
 
+	dbUser, plan, err := cfg.userPlan(req.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "", err)
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	if cfg.requireVerifiedEmail && !dbUser.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "email address not verified", nil)
 		return
 	}
 
 	type newChirp struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -48,17 +105,57 @@ func (cfg *apiConfig) handlerNewChirp(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	if len(c.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	err = plan.CheckChirpLength(c.Body)
+	if err != nil {
+		respondWithEntitlementError(w, err)
 		return
 	}
 
-	c.Body = cleanBody(c.Body)
+	now := time.Now()
+
+	window, err := cfg.dbQueries.GetChirpRateWindow(req.Context(), database.GetChirpRateWindowParams{
+		UserID:    userID,
+		CreatedAt: now.Add(-entitlements.RateWindow),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to check chirp rate", err)
+		return
+	}
+
+	if retryAfter, exceeded := plan.ChirpRateExceeded(int(window.Sent), window.Oldest, now); exceeded {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		respondWithError(w, http.StatusTooManyRequests, "too many chirps, try again later", nil)
+		return
+	}
+
+	publishedAt := sql.NullTime{}
+	if c.PublishAt != nil {
+		pending, err := cfg.dbQueries.CountScheduledChirpsByUser(req.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to count scheduled chirps", err)
+			return
+		}
+
+		err = plan.CheckSchedule(*c.PublishAt, now, int(pending))
+		if err != nil {
+			respondWithEntitlementError(w, err)
+			return
+		}
+
+		publishedAt = sql.NullTime{Time: *c.PublishAt, Valid: true}
+	}
+
+	filtered := cfg.contentFilter.Apply(c.Body)
+	if filtered.Rejected() {
+		respondWithError(w, http.StatusBadRequest, "Chirp contains blocked content", nil)
+		return
+	}
 
 	dbChrip, err := cfg.dbQueries.NewChirp(req.Context(),
 		database.NewChirpParams{
-			Body:   c.Body,
-			UserID: userID,
+			Body:        filtered.Body,
+			UserID:      userID,
+			PublishedAt: publishedAt,
 		})
 
 	if err != nil {
@@ -66,24 +163,28 @@ func (cfg *apiConfig) handlerNewChirp(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		Id:        dbChrip.ID,
-		CreatedAt: dbChrip.CreatedAt,
-		UpdatedAt: dbChrip.UpdatedAt,
-		Body:      dbChrip.Body,
-		UserId:    dbChrip.UserID,
-	})
+	if filtered.Flagged() {
+		err = cfg.flagChirp(req.Context(), dbChrip, filtered)
+		if err != nil {
+			log.Printf("failed to flag chirp %s for review: %s", dbChrip.ID, err)
+		}
+	}
 
-}
+	// Scheduled chirps are announced by announceScheduledChirps once they
+	// go live.
+	if !dbChrip.PublishedAt.After(time.Now()) {
+		err = cfg.announceChirp(req.Context(), dbChrip)
+		if err != nil {
+			log.Printf("failed to queue %s webhooks for chirp %s: %s", eventChirpCreated, dbChrip.ID, err)
+		}
+	}
 
-func cleanBody(c string) string {
-	r := regexp.MustCompile("([Kk]erfuffle)|([Ss]harbert)|([Ff]ornax)")
+	respondWithJSON(w, http.StatusCreated, chirpFromDB(dbChrip))
 
-	return r.ReplaceAllLiteralString(c, "****")
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, req *http.Request) {
-	dbChirps, err := cfg.dbQueries.GetChirps(req.Context())
+	dbChirps, err := cfg.dbQueries.GetChirps(req.Context(), cfg.optionalViewer(req))
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed getting chirps from database", err)
@@ -93,13 +194,7 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, req *http.Request)
 	c := []Chirp{}
 
 	for _, item := range dbChirps {
-		c = append(c, Chirp{
-			Id:        item.ID,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-			Body:      item.Body,
-			UserId:    item.UserID,
-		})
+		c = append(c, chirpFromDB(item))
 	}
 
 	respondWithJSON(w, http.StatusOK, c)
@@ -114,36 +209,107 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, req *http.Request)
 	}
 
 	dbChirp, err := cfg.dbQueries.GetChirp(req.Context(), reqUUID)
-
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "failed to find chirp id", err)
 		return
 	}
 
-	c := Chirp{
-		Id:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserId:    dbChirp.UserID,
+	visible, err := cfg.chirpVisibleTo(req.Context(), dbChirp, cfg.optionalViewer(req))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up chirp author", err)
+		return
+	}
+	if !visible {
+		respondWithError(w, http.StatusNotFound, "failed to find chirp id", nil)
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, c)
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
 }
 
-func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
-	bearerToken, err := auth.GetBearerToken(req.Header)
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, req *http.Request) {
+	userID := principalFromContext(req.Context()).UserID
+
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "authorization missing", err)
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(bearerToken, cfg.secret)
+	dbChirp, err := cfg.dbQueries.GetChirp(req.Context(), chirpUUID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		respondWithError(w, http.StatusNotFound, "chirp not found", err)
+		return
+	}
+
+	if dbChirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "", nil)
+		return
+	}
+
+	if dbChirp.HiddenAt.Valid {
+		respondWithError(w, http.StatusForbidden, "chirp was hidden by a moderator", nil)
+		return
+	}
+
+	_, plan, err := cfg.userPlan(req.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	err = plan.Require(entitlements.EditChirps)
+	if err != nil {
+		respondWithEntitlementError(w, err)
+		return
+	}
+
+	type chirpUpdate struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	c := chirpUpdate{}
+	err = decoder.Decode(&c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	err = plan.CheckChirpLength(c.Body)
+	if err != nil {
+		respondWithEntitlementError(w, err)
+		return
+	}
+
+	filtered := cfg.contentFilter.Apply(c.Body)
+	if filtered.Rejected() {
+		respondWithError(w, http.StatusBadRequest, "Chirp contains blocked content", nil)
+		return
+	}
+
+	dbChirp, err = cfg.dbQueries.UpdateChirpBody(req.Context(), database.UpdateChirpBodyParams{
+		Body: filtered.Body,
+		ID:   dbChirp.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update chirp", err)
 		return
 	}
 
+	if filtered.Flagged() {
+		err = cfg.flagChirp(req.Context(), dbChirp, filtered)
+		if err != nil {
+			log.Printf("failed to flag chirp %s for review: %s", dbChirp.ID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
+}
+
+func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
+	userID := principalFromContext(req.Context()).UserID
+
 	chripUUID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
@@ -153,6 +319,7 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Reques
 	dbChirp, err := cfg.dbQueries.GetChirp(req.Context(), chripUUID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "chirp not found", err)
+		return
 	}
 
 	if dbChirp.UserID != userID {
@@ -163,6 +330,16 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Reques
 	err = cfg.dbQueries.DeleteChirp(req.Context(), dbChirp.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to delete", err)
+		return
+	}
+
+	// A scheduled chirp deleted before it went live was never announced,
+	// so there is nothing to retract.
+	if dbChirp.AnnouncedAt.Valid {
+		err = emitWebhookEvent(req.Context(), cfg.dbQueries, userID, eventChirpDeleted, chirpFromDB(dbChirp))
+		if err != nil {
+			log.Printf("failed to queue %s webhooks for chirp %s: %s", eventChirpDeleted, dbChirp.ID, err)
+		}
 	}
 
 	respondWithJSON(w, http.StatusNoContent, nil)
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/contentfilter"
+	"github.com/w0/chirpy/internal/database"
+)
+
+// decodeContentFilterRule reads and validates a rule from a request body.
+// Rules made through the API default to whole word matching, like the
+// column does.
+func decodeContentFilterRule(w http.ResponseWriter, req *http.Request) (contentfilter.Rule, bool) {
+	rule := contentfilter.Rule{WholeWord: true}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&rule)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return contentfilter.Rule{}, false
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return contentfilter.Rule{}, false
+	}
+
+	return rule, true
+}
+
+// reloadContentFilterAfterChange applies a rule change straight away. The
+// change is already stored, so a failure here is only logged; the periodic
+// reload picks it up later.
+func (cfg *apiConfig) reloadContentFilterAfterChange(req *http.Request) {
+	err := cfg.reloadContentFilter(req.Context())
+	if err != nil {
+		log.Printf("failed to reload content filter: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerGetContentFilterRules(w http.ResponseWriter, req *http.Request) {
+	dbRules, err := cfg.dbQueries.ListContentFilterRules(req.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting filter rules from database", err)
+		return
+	}
+
+	rules := []ContentFilterRule{}
+	for _, item := range dbRules {
+		rules = append(rules, contentFilterRuleFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, rules)
+}
+
+func (cfg *apiConfig) handlerNewContentFilterRule(w http.ResponseWriter, req *http.Request) {
+	rule, ok := decodeContentFilterRule(w, req)
+	if !ok {
+		return
+	}
+
+	dbRule, err := cfg.dbQueries.CreateContentFilterRule(req.Context(), database.CreateContentFilterRuleParams{
+		Pattern:   rule.Pattern,
+		Action:    string(rule.Action),
+		WholeWord: rule.WholeWord,
+		Leet:      rule.Leet,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create filter rule", err)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(req)
+
+	respondWithJSON(w, http.StatusCreated, contentFilterRuleFromDB(dbRule))
+}
+
+func (cfg *apiConfig) handlerUpdateContentFilterRule(w http.ResponseWriter, req *http.Request) {
+	ruleID, err := uuid.Parse(req.PathValue("ruleID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	rule, ok := decodeContentFilterRule(w, req)
+	if !ok {
+		return
+	}
+
+	dbRule, err := cfg.dbQueries.UpdateContentFilterRule(req.Context(), database.UpdateContentFilterRuleParams{
+		Pattern:   rule.Pattern,
+		Action:    string(rule.Action),
+		WholeWord: rule.WholeWord,
+		Leet:      rule.Leet,
+		ID:        ruleID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "filter rule not found", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update filter rule", err)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(req)
+
+	respondWithJSON(w, http.StatusOK, contentFilterRuleFromDB(dbRule))
+}
+
+func (cfg *apiConfig) handlerDeleteContentFilterRule(w http.ResponseWriter, req *http.Request) {
+	ruleID, err := uuid.Parse(req.PathValue("ruleID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.DeleteContentFilterRule(req.Context(), ruleID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete filter rule", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "filter rule not found", nil)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(req)
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/mailer"
+)
+
+const (
+	emailPurposeVerify = "verify"
+	emailPurposeChange = "change"
+
+	emailVerifyTTL = 48 * time.Hour
+	emailChangeTTL = 24 * time.Hour
+)
+
+// validEmail accepts a bare address such as walt@example.com, without a
+// display name or angle brackets.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// sendEmailToken stores a single use token for email and mails a link
+// containing it. Earlier tokens for the same purpose stop working.
+func (cfg *apiConfig) sendEmailToken(ctx context.Context, userID uuid.UUID, email, purpose string) error {
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := emailVerifyTTL
+	if purpose == emailPurposeChange {
+		ttl = emailChangeTTL
+	}
+
+	err = cfg.dbQueries.InvalidateEmailTokens(ctx, database.InvalidateEmailTokensParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = cfg.dbQueries.CreateEmailToken(ctx, database.CreateEmailTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Email:     email,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL("/verify-email", url.Values{"token": {token}})
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body:    fmt.Sprintf("Confirm this email address for your Chirpy account by opening this link within %s:\n\n%s\n", ttl, link),
+	}
+
+	if purpose == emailPurposeChange {
+		msg.Subject = "Confirm your new Chirpy email address"
+		msg.Body = fmt.Sprintf("Someone asked to move a Chirpy account to this email address.\n\n"+
+			"Open this link within %s to confirm the change:\n\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n", ttl, link)
+	}
+
+	cfg.sendMail(msg)
+
+	return nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	type verifyEmail struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var v verifyEmail
+	err := decoder.Decode(&v)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	// The token is only used up if the change it carries is made, so a link
+	// that fails part way can be tried again.
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to start transaction", err)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	emailToken, err := qtx.ConsumeEmailToken(req.Context(), auth.HashToken(v.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired token", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to consume token", err)
+		return
+	}
+
+	var changed *mailer.Message
+
+	switch emailToken.Purpose {
+	case emailPurposeVerify:
+		n, err := qtx.MarkEmailVerified(req.Context(), database.MarkEmailVerifiedParams{
+			ID:    emailToken.UserID,
+			Email: emailToken.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to verify email", err)
+			return
+		}
+
+		if n == 0 {
+			respondWithError(w, http.StatusBadRequest, "email address has changed since this link was sent", nil)
+			return
+		}
+
+	case emailPurposeChange:
+		oldUser, err := qtx.GetUserByID(req.Context(), emailToken.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "user not found", err)
+			return
+		}
+
+		_, err = qtx.ChangeEmail(req.Context(), database.ChangeEmailParams{
+			Email: emailToken.Email,
+			ID:    emailToken.UserID,
+		})
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "email address already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to change email", err)
+			return
+		}
+
+		err = qtx.RevokeUserRefreshTokens(req.Context(), emailToken.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to revoke sessions", err)
+			return
+		}
+
+		changed = &mailer.Message{
+			To:      oldUser.Email,
+			Subject: "Your Chirpy email address was changed",
+			Body: fmt.Sprintf("The email address on your Chirpy account was changed to %s.\n\n"+
+				"If you didn't make this change, reset your password and contact support.\n", emailToken.Email),
+		}
+
+	default:
+		respondWithError(w, http.StatusBadRequest, "invalid token", nil)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to commit", err)
+		return
+	}
+
+	if changed != nil {
+		cfg.sendMail(*changed)
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate
+// value for a unique column.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, req *http.Request) {
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), principalFromContext(req.Context()).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	if dbUser.EmailVerified {
+		respondWithError(w, http.StatusConflict, "email address already verified", nil)
+		return
+	}
+
+	err = cfg.sendEmailToken(req.Context(), dbUser.ID, dbUser.Email, emailPurposeVerify)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to send verification email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
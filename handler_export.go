@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/export"
+)
+
+const (
+	// exportSyncChirpLimit is the largest account, by chirp count, that is
+	// exported within the request. Bigger ones are built in the background.
+	exportSyncChirpLimit = 1000
+	exportPageSize       = 500
+	exportTTL            = 7 * 24 * time.Hour
+	// exportLease is how long an export may stay running before it is
+	// presumed abandoned, say by a crash, and built again.
+	exportLease = 30 * time.Minute
+	// exportReuseWindow is how long a finished export is handed back
+	// instead of building a new one.
+	exportReuseWindow = 24 * time.Hour
+)
+
+// writeUserExport streams a ZIP of everything stored about userID to w.
+func (cfg *apiConfig) writeUserExport(ctx context.Context, userID uuid.UUID, w io.Writer) error {
+	dbUser, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	archive := export.NewArchive(w)
+
+	type profile struct {
+		User
+		TotpEnabled bool `json:"totp_enabled"`
+	}
+
+	err = archive.WriteJSON("profile.json", profile{
+		User:        userFromDB(dbUser),
+		TotpEnabled: dbUser.TotpEnabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = archive.WriteJSONArray("chirps.json", cfg.exportChirps(ctx, userID))
+	if err != nil {
+		return err
+	}
+
+	dbSessions, err := cfg.dbQueries.ListUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	type session struct {
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt time.Time  `json:"expires_at"`
+		RevokedAt *time.Time `json:"revoked_at"`
+		Scope     string     `json:"scope"`
+		ClientID  *uuid.UUID `json:"client_id,omitempty"`
+	}
+
+	sessions := []session{}
+	for _, item := range dbSessions {
+		s := session{
+			CreatedAt: item.CreatedAt,
+			ExpiresAt: item.ExpiresAt,
+			Scope:     item.Scope,
+		}
+		if item.RevokedAt.Valid {
+			s.RevokedAt = &item.RevokedAt.Time
+		}
+		if item.ClientID.Valid {
+			s.ClientID = &item.ClientID.UUID
+		}
+		sessions = append(sessions, s)
+	}
+
+	err = archive.WriteJSON("sessions.json", sessions)
+	if err != nil {
+		return err
+	}
+
+	dbTokens, err := cfg.dbQueries.ListAllPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	tokens := []PersonalAccessToken{}
+	for _, item := range dbTokens {
+		tokens = append(tokens, personalAccessTokenFromDB(item))
+	}
+
+	err = archive.WriteJSON("personal_access_tokens.json", tokens)
+	if err != nil {
+		return err
+	}
+
+	dbIdentities, err := cfg.dbQueries.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	type identity struct {
+		Provider  string    `json:"provider"`
+		Subject   string    `json:"subject"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	identities := []identity{}
+	for _, item := range dbIdentities {
+		identities = append(identities, identity{
+			Provider:  item.Provider,
+			Subject:   item.Subject,
+			Email:     item.Email,
+			CreatedAt: item.CreatedAt,
+		})
+	}
+
+	err = archive.WriteJSON("identities.json", identities)
+	if err != nil {
+		return err
+	}
+
+	dbPasskeys, err := cfg.dbQueries.ListWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	passkeys := []Passkey{}
+	for _, item := range dbPasskeys {
+		passkeys = append(passkeys, passkeyFromDB(item))
+	}
+
+	err = archive.WriteJSON("passkeys.json", passkeys)
+	if err != nil {
+		return err
+	}
+
+	dbSubscription, err := cfg.dbQueries.GetSubscriptionByUser(ctx, userID)
+	if err == nil {
+		err = archive.WriteJSON("subscription.json", subscriptionFromDB(dbSubscription))
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dbCustomers, err := cfg.dbQueries.ListBillingCustomersByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	type billingCustomer struct {
+		Provider   string    `json:"provider"`
+		CustomerID string    `json:"customer_id"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	customers := []billingCustomer{}
+	for _, item := range dbCustomers {
+		customers = append(customers, billingCustomer{
+			Provider:   item.Provider,
+			CustomerID: item.CustomerID,
+			CreatedAt:  item.CreatedAt,
+		})
+	}
+
+	err = archive.WriteJSON("billing_customers.json", customers)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// exportChirps pages through a user's chirps so only one page is in memory
+// at a time.
+func (cfg *apiConfig) exportChirps(ctx context.Context, userID uuid.UUID) func() (any, bool, error) {
+	var page []database.Chirp
+	var last database.Chirp
+	done := false
+
+	return func() (any, bool, error) {
+		if len(page) == 0 && !done {
+			var err error
+			page, err = cfg.dbQueries.GetChirpsByUserPage(ctx, database.GetChirpsByUserPageParams{
+				UserID:         userID,
+				AfterCreatedAt: last.CreatedAt,
+				AfterID:        last.ID,
+				PageSize:       exportPageSize,
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			done = len(page) < exportPageSize
+		}
+
+		if len(page) == 0 {
+			return nil, false, nil
+		}
+
+		last, page = page[0], page[1:]
+
+		return chirpFromDB(last), true, nil
+	}
+}
+
+type DataExport struct {
+	Id          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func dataExportFromDB(e database.DataExport) DataExport {
+	de := DataExport{
+		Id:        e.ID,
+		Status:    e.Status,
+		CreatedAt: e.CreatedAt,
+		Error:     e.Error.String,
+	}
+
+	if e.CompletedAt.Valid {
+		de.CompletedAt = &e.CompletedAt.Time
+	}
+
+	if e.ExpiresAt.Valid {
+		de.ExpiresAt = &e.ExpiresAt.Time
+	}
+
+	if e.Status == "complete" {
+		de.DownloadURL = fmt.Sprintf("/api/users/me/exports/%s/download", e.ID)
+	}
+
+	return de
+}
+
+func exportFilename(userID uuid.UUID) string {
+	return fmt.Sprintf("chirpy-export-%s.zip", userID)
+}
+
+// handlerExportUser streams the export straight back for small accounts and
+// queues a background export for large ones, or when ?async=true. A queued
+// or recent export is answered with 200 rather than 202.
+func (cfg *apiConfig) handlerExportUser(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	count, err := cfg.dbQueries.CountChirpsByUser(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count chirps", err)
+		return
+	}
+
+	if count > exportSyncChirpLimit || req.URL.Query().Get("async") == "true" {
+		cfg.queueDataExport(w, req, p.UserID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(p.UserID)))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are gone by now, so a failure can only cut the archive short.
+	err = cfg.writeUserExport(req.Context(), p.UserID, w)
+	if err != nil {
+		log.Printf("export for %s failed: %s", p.UserID, err)
+	}
+}
+
+// queueDataExport starts a background export, unless one is already queued
+// or was finished within exportReuseWindow, in which case that one is
+// returned instead. This keeps a user from filling the export disk.
+func (cfg *apiConfig) queueDataExport(w http.ResponseWriter, req *http.Request, userID uuid.UUID) {
+	existing := func() (database.DataExport, error) {
+		return cfg.dbQueries.GetReusableDataExport(req.Context(), database.GetReusableDataExportParams{
+			UserID:      userID,
+			CompletedAt: sql.NullTime{Time: time.Now().Add(-exportReuseWindow), Valid: true},
+		})
+	}
+
+	dbExport, err := existing()
+	if err == nil {
+		w.Header().Set("Location", fmt.Sprintf("/api/users/me/exports/%s", dbExport.ID))
+		respondWithJSON(w, http.StatusOK, dataExportFromDB(dbExport))
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up exports", err)
+		return
+	}
+
+	status := http.StatusAccepted
+	dbExport, err = cfg.dbQueries.CreateDataExport(req.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Another request queued one in the meantime.
+		status = http.StatusOK
+		dbExport, err = existing()
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to queue export", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/users/me/exports/%s", dbExport.ID))
+	respondWithJSON(w, status, dataExportFromDB(dbExport))
+}
+
+func (cfg *apiConfig) getOwnDataExport(w http.ResponseWriter, req *http.Request) (database.DataExport, bool) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return database.DataExport{}, false
+	}
+
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return database.DataExport{}, false
+	}
+
+	dbExport, err := cfg.dbQueries.GetDataExport(req.Context(), database.GetDataExportParams{
+		ID:     exportID,
+		UserID: p.UserID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "export not found", nil)
+		return database.DataExport{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up export", err)
+		return database.DataExport{}, false
+	}
+
+	return dbExport, true
+}
+
+func (cfg *apiConfig) handlerGetDataExport(w http.ResponseWriter, req *http.Request) {
+	dbExport, ok := cfg.getOwnDataExport(w, req)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, dataExportFromDB(dbExport))
+}
+
+func (cfg *apiConfig) handlerDownloadDataExport(w http.ResponseWriter, req *http.Request) {
+	dbExport, ok := cfg.getOwnDataExport(w, req)
+	if !ok {
+		return
+	}
+
+	if dbExport.Status != "complete" || !dbExport.FilePath.Valid {
+		respondWithError(w, http.StatusConflict, "export is not ready", nil)
+		return
+	}
+
+	f, err := os.Open(dbExport.FilePath.String)
+	if err != nil {
+		respondWithError(w, http.StatusGone, "export no longer available", err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(dbExport.UserID)))
+	http.ServeContent(w, req, "", dbExport.CompletedAt.Time, f)
+}
+
+// buildPendingExports works through queued and abandoned exports until none
+// are left.
+func (cfg *apiConfig) buildPendingExports(ctx context.Context) error {
+	for {
+		dbExport, err := cfg.dbQueries.ClaimPendingDataExport(ctx, time.Now().Add(-exportLease))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cfg.buildExport(ctx, dbExport)
+		if err != nil {
+			log.Printf("export %s failed: %s", dbExport.ID, err)
+
+			err = cfg.dbQueries.FailDataExport(ctx, database.FailDataExportParams{
+				Error: sql.NullString{String: err.Error(), Valid: true},
+				ID:    dbExport.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (cfg *apiConfig) buildExport(ctx context.Context, dbExport database.DataExport) error {
+	path := filepath.Join(cfg.exportDir, dbExport.ID.String()+".zip")
+
+	tmp, err := os.CreateTemp(cfg.exportDir, "export-*.zip.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = cfg.writeUserExport(ctx, dbExport.UserID, tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	n, err := cfg.dbQueries.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		FilePath:  sql.NullString{String: path, Valid: true},
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(exportTTL), Valid: true},
+		ID:        dbExport.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The export was deleted while it was being built, most likely because
+	// its owner was purged. Nothing refers to the file any more.
+	if n == 0 {
+		removeExportFile(path)
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) deleteExpiredExports(ctx context.Context) error {
+	expired, err := cfg.dbQueries.DeleteExpiredDataExports(ctx)
+	if err != nil {
+		return err
+	}
+
+	removeExportFiles(expired)
+
+	return nil
+}
+
+// removeExportFiles deletes the files behind exports whose rows are gone.
+func removeExportFiles(exports []database.DataExport) {
+	for _, e := range exports {
+		if e.FilePath.Valid {
+			removeExportFile(e.FilePath.String)
+		}
+	}
+}
+
+func removeExportFile(path string) {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to remove export %s: %s", path, err)
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+const (
+	oauthCodeTTL         = 5 * time.Minute
+	oauthAccessTokenTTL  = time.Hour
+	oauthRefreshTokenTTL = 60 * 24 * time.Hour
+)
+
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsWrite:  "Post chirps as you",
+	auth.ScopeChirpsDelete: "Delete your chirps",
+	auth.ScopeProfileWrite: "Change your profile, email and password",
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+{{if .ClientName}}
+<h1>{{.ClientName}} wants to access your Chirpy account</h1>
+<p>It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}
+</ul>
+{{end}}
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Form}}
+<form method="post" action="/oauth/authorize">
+{{range $name, $values := .Form}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}
+<p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+<p><label>Password <input type="password" name="password"></label></p>
+<p><label>Two-factor code (if enabled) <input type="text" name="totp_code" autocomplete="one-time-code"></label></p>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type consentPage struct {
+	ClientName string
+	Scopes     []string
+	Error      string
+	Email      string
+	// Form carries the authorization request through the consent form.
+	Form url.Values
+}
+
+func renderConsentPage(w http.ResponseWriter, code int, page consentPage) {
+	// The consent form must not be framed, or another site could trick a
+	// user into approving it.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+
+	err := consentTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("failed rendering consent page: %s", err)
+	}
+}
+
+// oauthError is an error that, per RFC 6749, is reported back to the
+// client through its redirect URI.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+type authorizationRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	State         string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// parseAuthorizationRequest validates the parameters of an authorization
+// request. A non-empty fatal means the client or redirect URI could not be
+// trusted, so the error must be shown to the user rather than redirected.
+func (cfg *apiConfig) parseAuthorizationRequest(ctx context.Context, v url.Values) (ar authorizationRequest, oerr *oauthError, fatal string, err error) {
+	clientID, err := uuid.Parse(v.Get("client_id"))
+	if err != nil {
+		return ar, nil, "unknown client", nil
+	}
+
+	ar.Client, err = cfg.dbQueries.GetOAuthClient(ctx, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ar, nil, "unknown client", nil
+	}
+	if err != nil {
+		return ar, nil, "", err
+	}
+
+	registered := strings.Fields(ar.Client.RedirectUris)
+	ar.RedirectURI = v.Get("redirect_uri")
+	if ar.RedirectURI == "" && len(registered) == 1 {
+		ar.RedirectURI = registered[0]
+	}
+
+	if !slices.Contains(registered, ar.RedirectURI) {
+		return ar, nil, "redirect_uri is not registered for this client", nil
+	}
+
+	ar.State = v.Get("state")
+
+	if v.Get("response_type") != "code" {
+		return ar, &oauthError{"unsupported_response_type", "only the code response type is supported"}, "", nil
+	}
+
+	ar.CodeChallenge = v.Get("code_challenge")
+	if ar.CodeChallenge == "" || v.Get("code_challenge_method") != "S256" {
+		return ar, &oauthError{"invalid_request", "PKCE with the S256 method is required"}, "", nil
+	}
+
+	requested := auth.ParseScopes(v.Get("scope"))
+	if len(requested) == 0 {
+		requested = auth.ParseScopes(ar.Client.Scope)
+	}
+
+	scopes, ok := auth.NarrowScopes(auth.ParseScopes(ar.Client.Scope), requested)
+	if !ok {
+		return ar, &oauthError{"invalid_scope", "scope not allowed for this client"}, "", nil
+	}
+	ar.Scopes = scopes
+
+	return ar, nil, "", nil
+}
+
+func redirectWithParams(w http.ResponseWriter, req *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "invalid redirect uri", err)
+		return
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, req, u.String(), http.StatusFound)
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, req *http.Request, ar authorizationRequest, oerr *oauthError) {
+	params := url.Values{}
+	params.Set("error", oerr.Code)
+	params.Set("error_description", oerr.Description)
+	if ar.State != "" {
+		params.Set("state", ar.State)
+	}
+
+	redirectWithParams(w, req, ar.RedirectURI, params)
+}
+
+// authorizationForm keeps only the parameters the consent form needs to
+// send back, so credentials never end up echoed into the page.
+func authorizationForm(v url.Values) url.Values {
+	form := url.Values{}
+	for _, k := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "code_challenge", "code_challenge_method"} {
+		if v.Has(k) {
+			form.Set(k, v.Get(k))
+		}
+	}
+	return form
+}
+
+func describeScopes(scopes []string) []string {
+	descriptions := []string{}
+	for _, s := range scopes {
+		if d, ok := scopeDescriptions[s]; ok {
+			descriptions = append(descriptions, d)
+		} else {
+			descriptions = append(descriptions, s)
+		}
+	}
+	return descriptions
+}
+
+// handlerOAuthAuthorize shows the consent screen for an authorization
+// request.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	ar, oerr, fatal, err := cfg.parseAuthorizationRequest(req.Context(), q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up client", err)
+		return
+	}
+	if fatal != "" {
+		renderConsentPage(w, http.StatusBadRequest, consentPage{Error: fatal})
+		return
+	}
+	if oerr != nil {
+		redirectWithOAuthError(w, req, ar, oerr)
+		return
+	}
+
+	renderConsentPage(w, http.StatusOK, consentPage{
+		ClientName: ar.Client.Name,
+		Scopes:     describeScopes(ar.Scopes),
+		Form:       authorizationForm(q),
+	})
+}
+
+// handlerOAuthDecision handles the consent form. The user signs in as part
+// of approving, so apps never see their password.
+func (cfg *apiConfig) handlerOAuthDecision(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid form", err)
+		return
+	}
+	form := req.PostForm
+
+	ar, oerr, fatal, err := cfg.parseAuthorizationRequest(req.Context(), form)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up client", err)
+		return
+	}
+	if fatal != "" {
+		renderConsentPage(w, http.StatusBadRequest, consentPage{Error: fatal})
+		return
+	}
+	if oerr != nil {
+		redirectWithOAuthError(w, req, ar, oerr)
+		return
+	}
+
+	if form.Get("decision") != "approve" {
+		redirectWithOAuthError(w, req, ar, &oauthError{"access_denied", "the user denied the request"})
+		return
+	}
+
+	email := form.Get("email")
+	retry := func(code int, msg string) {
+		renderConsentPage(w, code, consentPage{
+			ClientName: ar.Client.Name,
+			Scopes:     describeScopes(ar.Scopes),
+			Error:      msg,
+			Email:      email,
+			Form:       authorizationForm(form),
+		})
+	}
+
+	ip := clientIP(req)
+
+	if retryAfter, blocked := cfg.loginThrottle.blocked(email, ip); blocked {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		retry(http.StatusTooManyRequests, "Too many attempts, try again later.")
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByEmail(req.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.DummyCheckPassword(form.Get("password"))
+		cfg.loginThrottle.fail(email, ip)
+		retry(http.StatusUnauthorized, "Invalid email or password.")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up user", err)
+		return
+	}
+
+	needsRehash, err := auth.CheckPasswordHash(form.Get("password"), dbUser.HashedPassword)
+	if err != nil {
+		cfg.loginThrottle.fail(email, ip)
+		retry(http.StatusUnauthorized, "Invalid email or password.")
+		return
+	}
+
+	if needsRehash {
+		cfg.rehashPassword(req.Context(), dbUser, form.Get("password"))
+	}
+
+	if dbUser.TotpEnabled {
+		ok, err := cfg.checkSecondFactor(req.Context(), dbUser, form.Get("totp_code"))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to check code", err)
+			return
+		}
+		if !ok {
+			cfg.loginThrottle.fail(email, ip)
+			retry(http.StatusUnauthorized, "Invalid two-factor code.")
+			return
+		}
+	}
+
+	cfg.loginThrottle.succeed(email)
+
+	if accountFromDB(dbUser).Check(time.Now()) != nil {
+		retry(http.StatusForbidden, "This account is suspended.")
+		return
+	}
+
+	// The account may have lost scopes, say through a role change, since the
+	// request was validated.
+	scopes, ok := auth.NarrowScopes(cfg.allowedScopes(dbUser), ar.Scopes)
+	if !ok {
+		redirectWithOAuthError(w, req, ar, &oauthError{"invalid_scope", "scope exceeds what the user may grant"})
+		return
+	}
+
+	code, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create authorization code", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreateOAuthAuthorizationCode(req.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      ar.Client.ID,
+		UserID:        dbUser.ID,
+		RedirectUri:   ar.RedirectURI,
+		Scope:         auth.FormatScopes(scopes),
+		CodeChallenge: ar.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing authorization code", err)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if ar.State != "" {
+		params.Set("state", ar.State)
+	}
+
+	redirectWithParams(w, req, ar.RedirectURI, params)
+}
+
+// respondWithOAuthError uses the RFC 6749 error format, which OAuth
+// client libraries expect from the token endpoint.
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errorResponse{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+// authenticateOAuthClient checks client credentials sent with HTTP basic
+// auth or in the form body. Public clients only send their ID.
+func (cfg *apiConfig) authenticateOAuthClient(w http.ResponseWriter, req *http.Request) (database.OauthClient, bool) {
+	id, secret, basic := req.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+
+	invalid := func() (database.OauthClient, bool) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed", nil)
+		return database.OauthClient{}, false
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return invalid()
+	}
+
+	client, err := cfg.dbQueries.GetOAuthClient(req.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return invalid()
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return database.OauthClient{}, false
+	}
+
+	if client.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+			return invalid()
+		}
+	} else if secret != "" {
+		return invalid()
+	}
+
+	return client, true
+}
+
+// handlerOAuthToken is the token endpoint for the authorization_code and
+// refresh_token grants.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body", err)
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, req)
+	if !ok {
+		return
+	}
+
+	form := req.PostForm
+
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.dbQueries.ConsumeOAuthAuthorizationCode(req.Context(), auth.HashToken(form.Get("code")))
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code", nil)
+			return
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+			return
+		}
+
+		if code.ClientID != client.ID || code.RedirectUri != form.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect uri", nil)
+			return
+		}
+
+		if !auth.VerifyPKCE(form.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match", nil)
+			return
+		}
+
+		cfg.issueOAuthTokens(w, req, client, code.UserID, auth.ParseScopes(code.Scope))
+	case "refresh_token":
+		dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(req.Context(), form.Get("refresh_token"))
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token", nil)
+			return
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+			return
+		}
+
+		if !dbRefreshToken.ClientID.Valid || dbRefreshToken.ClientID.UUID != client.ID ||
+			dbRefreshToken.RevokedAt.Valid || dbRefreshToken.ExpiresAt.Before(time.Now()) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token", nil)
+			return
+		}
+
+		scopes := auth.ParseScopes(dbRefreshToken.Scope)
+		if form.Has("scope") {
+			scopes, ok = auth.NarrowScopes(scopes, auth.ParseScopes(form.Get("scope")))
+			if !ok {
+				respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "scope exceeds the original grant", nil)
+				return
+			}
+		}
+
+		// Refresh tokens are rotated, so a stolen one stops working as
+		// soon as either party uses it. Only the request that revokes it
+		// gets new tokens, even if two race.
+		n, err := cfg.dbQueries.RotateRefreshToken(req.Context(), dbRefreshToken.Token)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+			return
+		}
+		if n == 0 {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token", nil)
+			return
+		}
+
+		cfg.issueOAuthTokens(w, req, client, dbRefreshToken.UserID, scopes)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "", nil)
+	}
+}
+
+func (cfg *apiConfig) issueOAuthTokens(w http.ResponseWriter, req *http.Request, client database.OauthClient, userID uuid.UUID, scopes []string) {
+	_, err := cfg.activeUser(req.Context(), userID)
+	var restriction *moderation.Restriction
+	if errors.As(err, &restriction) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", restriction.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return
+	}
+
+	accessToken, err := auth.MakeJWT(userID, cfg.secret, oauthAccessTokenTTL, append(cfg.jwtOptions(scopes...), auth.WithClientID(client.ID))...)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.CreateClientRefreshToken(req.Context(), database.CreateClientRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(oauthRefreshTokenTTL),
+		Scope:     auth.FormatScopes(scopes),
+		ClientID:  uuid.NullUUID{UUID: client.ID, Valid: true},
+	})
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return
+	}
+
+	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        auth.FormatScopes(scopes),
+	})
+}
+
+// handlerOAuthRevoke implements RFC 7009. Access tokens are JWTs and cannot
+// be revoked one by one, so only refresh tokens are accepted; an app's
+// access tokens stop working once it holds no live refresh token. Unknown
+// tokens are not an error.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body", err)
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, req)
+	if !ok {
+		return
+	}
+
+	dbRefreshToken, err := cfg.dbQueries.GetRefreshToken(req.Context(), req.PostForm.Get("token"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+		return
+	}
+
+	if err == nil && dbRefreshToken.ClientID.Valid && dbRefreshToken.ClientID.UUID == client.ID && !dbRefreshToken.RevokedAt.Valid {
+		now := time.Now()
+		err = cfg.dbQueries.SetRevokedAt(req.Context(), database.SetRevokedAtParams{
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+			UpdatedAt: now,
+			Token:     dbRefreshToken.Token,
+		})
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) deleteExpiredOAuthCodes(ctx context.Context) error {
+	return cfg.dbQueries.DeleteExpiredOAuthAuthorizationCodes(ctx)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+// oauthClientScopes are the scopes third-party apps may ask for. Admin is
+// never handed to an app.
+var oauthClientScopes = []string{
+	auth.ScopeChirpsWrite,
+	auth.ScopeChirpsDelete,
+	auth.ScopeProfileWrite,
+}
+
+type OAuthClient struct {
+	Id           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func oauthClientFromDB(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		Id:           c.ID,
+		Name:         c.Name,
+		Confidential: c.SecretHash.Valid,
+		RedirectURIs: strings.Fields(c.RedirectUris),
+		Scopes:       auth.ParseScopes(c.Scope),
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// validRedirectURI accepts absolute https URIs, and http ones only for
+// loopback addresses so native apps and local development work.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+func (cfg *apiConfig) handlerNewOAuthClient(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type newClient struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// Confidential clients can keep a secret, such as server side
+		// apps. Public ones, like mobile apps, rely on PKCE alone.
+		Confidential bool `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var c newClient
+	err := decoder.Decode(&c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if c.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+
+	if len(c.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one redirect uri is required", nil)
+		return
+	}
+
+	for _, uri := range c.RedirectURIs {
+		if !validRedirectURI(uri) || strings.ContainsAny(uri, " \t\n") {
+			respondWithError(w, http.StatusBadRequest, "invalid redirect uri "+uri, nil)
+			return
+		}
+	}
+
+	if len(c.Scopes) == 0 {
+		c.Scopes = oauthClientScopes
+	}
+
+	scopes, ok := auth.NarrowScopes(oauthClientScopes, c.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid scope requested", nil)
+		return
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if c.Confidential {
+		secret, err = auth.MakeOpaqueToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to create client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := cfg.dbQueries.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		OwnerID:      p.UserID,
+		Name:         c.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(c.RedirectURIs, " "),
+		Scope:        auth.FormatScopes(scopes),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing client", err)
+		return
+	}
+
+	client := oauthClientFromDB(dbClient)
+	client.Secret = secret
+
+	respondWithJSON(w, http.StatusCreated, client)
+}
+
+func (cfg *apiConfig) handlerGetOAuthClients(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	dbClients, err := cfg.dbQueries.ListOAuthClients(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting clients from database", err)
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, item := range dbClients {
+		clients = append(clients, oauthClientFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerDeleteOAuthClient removes an app along with every refresh token
+// issued to it.
+func (cfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	clientID, err := uuid.Parse(req.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.DeleteOAuthClient(req.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: p.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete client", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "client not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
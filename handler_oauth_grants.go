@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+)
+
+// OAuthGrant is an app the user has authorized and not yet revoked.
+type OAuthGrant struct {
+	ClientID   uuid.UUID `json:"client_id"`
+	Name       string    `json:"name"`
+	GrantedAt  time.Time `json:"granted_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func (cfg *apiConfig) handlerGetOAuthGrants(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	dbGrants, err := cfg.dbQueries.ListOAuthGrants(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting authorized apps from database", err)
+		return
+	}
+
+	grants := []OAuthGrant{}
+	for _, item := range dbGrants {
+		grants = append(grants, OAuthGrant{
+			ClientID:   item.ID,
+			Name:       item.Name,
+			GrantedAt:  item.GrantedAt,
+			LastUsedAt: item.LastUsedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, grants)
+}
+
+// handlerRevokeOAuthGrant revokes every refresh token the user has given
+// an app. Its access tokens stop working too, since they are only honored
+// while the grant stands.
+func (cfg *apiConfig) handlerRevokeOAuthGrant(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	clientID, err := uuid.Parse(req.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.RevokeOAuthGrant(req.Context(), database.RevokeOAuthGrantParams{
+		UserID:   p.UserID,
+		ClientID: uuid.NullUUID{UUID: clientID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke app", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "app not authorized", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/oidc"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute
+	// oidcStateCookie ties a login to the browser that started it, so a
+	// callback URL cannot be replayed into someone else's browser.
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var errUnverifiedIdentityEmail = errors.New("identity provider did not return a verified email")
+
+func (cfg *apiConfig) oidcProvider(w http.ResponseWriter, req *http.Request) (*oidc.Provider, bool) {
+	provider, ok := cfg.oidcProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "unknown identity provider", nil)
+		return nil, false
+	}
+
+	return provider, true
+}
+
+// setOIDCStateCookie stores a hash of state in the browser, scoped to the
+// provider's routes. An empty state clears the cookie. It has to be Lax
+// rather than Strict, or the provider's redirect back would not carry it.
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, provider *oidc.Provider, state string) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/" + provider.Name + "/",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if state == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Value = auth.HashToken(state)
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// handlerOIDCLogin starts a login by sending the browser to the provider.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.oidcProvider(w, req)
+	if !ok {
+		return
+	}
+
+	state, err := oidc.GenerateState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to generate state", err)
+		return
+	}
+
+	nonce, err := oidc.GenerateState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to generate nonce", err)
+		return
+	}
+
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to generate verifier", err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(req.Context(), state, nonce, verifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "identity provider unavailable", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreateOIDCLoginState(req.Context(), database.CreateOIDCLoginStateParams{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing login state", err)
+		return
+	}
+
+	cfg.setOIDCStateCookie(w, provider, state)
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes a login the provider has redirected back
+// from, and responds the same way handlerLogin does.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.oidcProvider(w, req)
+	if !ok {
+		return
+	}
+
+	q := req.URL.Query()
+
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(auth.HashToken(q.Get("state")))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "login was not started in this browser", nil)
+		return
+	}
+	cfg.setOIDCStateCookie(w, provider, "")
+
+	loginState, err := cfg.dbQueries.ConsumeOIDCLoginState(req.Context(), q.Get("state"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired login state", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up login state", err)
+		return
+	}
+	if loginState.Provider != provider.Name {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired login state", nil)
+		return
+	}
+
+	if e := q.Get("error"); e != "" {
+		respondWithError(w, http.StatusUnauthorized, "identity provider returned "+e, nil)
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(req.Context(), q.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "failed to exchange authorization code", err)
+		return
+	}
+
+	identity, err := provider.VerifyIDToken(req.Context(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid id token", err)
+		return
+	}
+
+	dbUser, err := cfg.userForIdentity(req.Context(), provider.Name, identity)
+	if errors.Is(err, errUnverifiedIdentityEmail) {
+		respondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to sign in", err)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		cfg.respondWithTwoFactorChallenge(w, dbUser)
+		return
+	}
+
+	cfg.issueSession(w, req, dbUser, cfg.allowedScopes(dbUser))
+}
+
+// userForIdentity finds the user an external identity belongs to. Unknown
+// identities are linked to the user with the same verified email, or to a
+// new user if there is none.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, providerName string, identity oidc.Identity) (database.User, error) {
+	dbIdentity, err := cfg.dbQueries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: providerName,
+		Subject:  identity.Subject,
+	})
+	if err == nil {
+		if identity.Email != "" && identity.Email != dbIdentity.Email {
+			err = cfg.dbQueries.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+				Email: identity.Email,
+				ID:    dbIdentity.ID,
+			})
+			if err != nil {
+				log.Printf("failed to update identity %s: %s", dbIdentity.ID, err)
+			}
+		}
+
+		return cfg.dbQueries.GetUserByID(ctx, dbIdentity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return database.User{}, errUnverifiedIdentityEmail
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	dbUser, err := qtx.GetUserByEmail(ctx, identity.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No usable password: the account can only sign in through the
+		// provider until the user sets one with a password reset.
+		dbUser, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:          identity.Email,
+			HashedPassword: "",
+		})
+	} else if err == nil && !dbUser.EmailVerified {
+		// Whoever registered this address never proved they own it, so
+		// they may not be the person signing in now. Take away their
+		// password and sessions before handing the account over.
+		err = errors.Join(
+			qtx.UpdatePassword(ctx, database.UpdatePasswordParams{HashedPassword: "", ID: dbUser.ID}),
+			qtx.RevokeUserRefreshTokens(ctx, dbUser.ID),
+			qtx.RevokeUserPersonalAccessTokens(ctx, dbUser.ID),
+		)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if !dbUser.EmailVerified {
+		_, err = qtx.MarkEmailVerified(ctx, database.MarkEmailVerifiedParams{
+			ID:    dbUser.ID,
+			Email: dbUser.Email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		dbUser.EmailVerified = true
+	}
+
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID:   dbUser.ID,
+		Provider: providerName,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return database.User{}, fmt.Errorf("linking identity: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return dbUser, nil
+}
+
+func (cfg *apiConfig) deleteExpiredOIDCLoginStates(ctx context.Context) error {
+	return cfg.dbQueries.DeleteExpiredOIDCLoginStates(ctx)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/webauthn"
+)
+
+const (
+	passkeyChallengeTTL = 5 * time.Minute
+
+	passkeyPurposeRegister = "register"
+	passkeyPurposeLogin    = "login"
+)
+
+type Passkey struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func passkeyFromDB(c database.WebauthnCredential) Passkey {
+	p := Passkey{
+		Id:        c.ID,
+		Name:      c.Name,
+		CreatedAt: c.CreatedAt,
+	}
+
+	if c.LastUsedAt.Valid {
+		p.LastUsedAt = &c.LastUsedAt.Time
+	}
+
+	return p
+}
+
+func (cfg *apiConfig) newPasskeyChallenge(ctx context.Context, userID uuid.NullUUID, purpose string) (database.WebauthnChallenge, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return database.WebauthnChallenge{}, err
+	}
+
+	return cfg.dbQueries.CreateWebauthnChallenge(ctx, database.CreateWebauthnChallengeParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(passkeyChallengeTTL),
+	})
+}
+
+func (cfg *apiConfig) consumePasskeyChallenge(w http.ResponseWriter, ctx context.Context, id uuid.UUID, purpose string) (database.WebauthnChallenge, bool) {
+	challenge, err := cfg.dbQueries.ConsumeWebauthnChallenge(ctx, database.ConsumeWebauthnChallengeParams{
+		ID:      id,
+		Purpose: purpose,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired challenge", nil)
+		return database.WebauthnChallenge{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up challenge", err)
+		return database.WebauthnChallenge{}, false
+	}
+
+	return challenge, true
+}
+
+type passkeyCeremony struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+	PublicKey   any       `json:"publicKey"`
+}
+
+func (cfg *apiConfig) handlerBeginPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	dbCredentials, err := cfg.dbQueries.ListWebauthnCredentials(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting passkeys from database", err)
+		return
+	}
+
+	existing := [][]byte{}
+	for _, item := range dbCredentials {
+		existing = append(existing, item.CredentialID)
+	}
+
+	challenge, err := cfg.newPasskeyChallenge(req.Context(), uuid.NullUUID{UUID: dbUser.ID, Valid: true}, passkeyPurposeRegister)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, passkeyCeremony{
+		ChallengeID: challenge.ID,
+		PublicKey:   cfg.webauthn.CreationOptions(challenge.Challenge, dbUser.ID[:], dbUser.Email, existing),
+	})
+}
+
+func (cfg *apiConfig) handlerFinishPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type registration struct {
+		ChallengeID uuid.UUID `json:"challenge_id"`
+		Name        string    `json:"name"`
+		Credential  struct {
+			Response struct {
+				ClientDataJSON    webauthn.URLEncodedBytes `json:"clientDataJSON"`
+				AttestationObject webauthn.URLEncodedBytes `json:"attestationObject"`
+			} `json:"response"`
+		} `json:"credential"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var r registration
+	err := decoder.Decode(&r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if r.Name == "" {
+		r.Name = "Passkey"
+	}
+
+	challenge, ok := cfg.consumePasskeyChallenge(w, req.Context(), r.ChallengeID, passkeyPurposeRegister)
+	if !ok {
+		return
+	}
+
+	if challenge.UserID.UUID != p.UserID {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired challenge", nil)
+		return
+	}
+
+	cred, err := cfg.webauthn.VerifyRegistration(challenge.Challenge, r.Credential.Response.ClientDataJSON, r.Credential.Response.AttestationObject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "passkey registration failed: "+err.Error(), err)
+		return
+	}
+
+	dbCredential, err := cfg.dbQueries.CreateWebauthnCredential(req.Context(), database.CreateWebauthnCredentialParams{
+		UserID:       p.UserID,
+		Name:         r.Name,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusConflict, "failed storing passkey", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, passkeyFromDB(dbCredential))
+}
+
+func (cfg *apiConfig) handlerGetPasskeys(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	dbCredentials, err := cfg.dbQueries.ListWebauthnCredentials(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting passkeys from database", err)
+		return
+	}
+
+	passkeys := []Passkey{}
+	for _, item := range dbCredentials {
+		passkeys = append(passkeys, passkeyFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, passkeys)
+}
+
+func (cfg *apiConfig) handlerDeletePasskey(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	passkeyID, err := uuid.Parse(req.PathValue("passkeyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.DeleteWebauthnCredential(req.Context(), database.DeleteWebauthnCredentialParams{
+		ID:     passkeyID,
+		UserID: p.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete passkey", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "passkey not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerBeginPasskeyLogin needs no email: the browser offers whichever
+// passkeys it holds, and the assertion names the credential used.
+func (cfg *apiConfig) handlerBeginPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	challenge, err := cfg.newPasskeyChallenge(req.Context(), uuid.NullUUID{}, passkeyPurposeLogin)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, passkeyCeremony{
+		ChallengeID: challenge.ID,
+		PublicKey:   cfg.webauthn.RequestOptions(challenge.Challenge),
+	})
+}
+
+func (cfg *apiConfig) handlerFinishPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	type passkeyLogin struct {
+		ChallengeID uuid.UUID `json:"challenge_id"`
+		Scopes      []string  `json:"scopes"`
+		Credential  struct {
+			RawID    webauthn.URLEncodedBytes `json:"rawId"`
+			Response struct {
+				ClientDataJSON    webauthn.URLEncodedBytes `json:"clientDataJSON"`
+				AuthenticatorData webauthn.URLEncodedBytes `json:"authenticatorData"`
+				Signature         webauthn.URLEncodedBytes `json:"signature"`
+			} `json:"response"`
+		} `json:"credential"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var login passkeyLogin
+	err := decoder.Decode(&login)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	challenge, ok := cfg.consumePasskeyChallenge(w, req.Context(), login.ChallengeID, passkeyPurposeLogin)
+	if !ok {
+		return
+	}
+
+	dbCredential, err := cfg.dbQueries.GetWebauthnCredential(req.Context(), login.Credential.RawID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "unknown passkey", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up passkey", err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), dbCredential.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up user", err)
+		return
+	}
+
+	ip := clientIP(req)
+
+	if retryAfter, blocked := cfg.loginThrottle.blocked(dbUser.Email, ip); blocked {
+		respondWithTooManyAttempts(w, retryAfter)
+		return
+	}
+
+	signCount, err := cfg.webauthn.VerifyAssertion(
+		challenge.Challenge,
+		webauthn.Credential{
+			ID:        dbCredential.CredentialID,
+			PublicKey: dbCredential.PublicKey,
+			SignCount: uint32(dbCredential.SignCount),
+		},
+		login.Credential.Response.ClientDataJSON,
+		login.Credential.Response.AuthenticatorData,
+		login.Credential.Response.Signature,
+	)
+	if err != nil {
+		cfg.loginThrottle.fail(dbUser.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "passkey verification failed", err)
+		return
+	}
+
+	// Guarded by the old count, so two racing logins cannot both move the
+	// counter forward from the same value.
+	n, err := cfg.dbQueries.UpdateWebauthnSignCount(req.Context(), database.UpdateWebauthnSignCountParams{
+		SignCount:   int64(signCount),
+		ID:          dbCredential.ID,
+		SignCount_2: dbCredential.SignCount,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update passkey", err)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, http.StatusUnauthorized, "passkey verification failed", nil)
+		return
+	}
+
+	cfg.loginThrottle.succeed(dbUser.Email)
+
+	scopes, ok := auth.NarrowScopes(cfg.allowedScopes(dbUser), login.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid scope requested", nil)
+		return
+	}
+
+	cfg.issueSession(w, req, dbUser, scopes)
+}
+
+func (cfg *apiConfig) deleteExpiredPasskeyChallenges(ctx context.Context) error {
+	return cfg.dbQueries.DeleteExpiredWebauthnChallenges(ctx)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/mailer"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	mailSendTimeout  = 30 * time.Second
+)
+
+// sendMail delivers msg in the background so that request timing does not
+// depend on whether an email was sent.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+
+		err := cfg.mailer.Send(ctx, msg)
+		if err != nil {
+			log.Printf("failed to send mail to %s: %s", msg.To, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) appURL(path string, query url.Values) string {
+	return cfg.baseURL + path + "?" + query.Encode()
+}
+
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, req *http.Request) {
+	type forgotPassword struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var f forgotPassword
+	err := decoder.Decode(&f)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	// Respond the same way whether or not the account exists.
+	dbUser, err := cfg.dbQueries.GetUserByEmail(req.Context(), f.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithJSON(w, http.StatusAccepted, struct{}{})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up user", err)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create reset token", err)
+		return
+	}
+
+	err = cfg.dbQueries.InvalidatePasswordResetTokens(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to invalidate reset tokens", err)
+		return
+	}
+
+	err = cfg.dbQueries.CreatePasswordResetToken(req.Context(), database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing reset token", err)
+		return
+	}
+
+	link := cfg.appURL("/reset-password", url.Values{"token": {token}})
+
+	cfg.sendMail(mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Use this link within %s to choose a new one:\n\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n", passwordResetTTL, link),
+	})
+
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
+
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, req *http.Request) {
+	type resetPassword struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var r resetPassword
+	err := decoder.Decode(&r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if !cfg.checkPassword(w, "password", r.Password) {
+		return
+	}
+
+	hashed, err := auth.HashPassword(r.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed hashing password", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to start transaction", err)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	resetToken, err := qtx.ConsumePasswordResetToken(req.Context(), auth.HashToken(r.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired reset token", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to consume reset token", err)
+		return
+	}
+
+	err = qtx.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		HashedPassword: hashed,
+		ID:             resetToken.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update password", err)
+		return
+	}
+
+	err = qtx.RevokeUserRefreshTokens(req.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke sessions", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to commit", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+const (
+	reportPageSize    = 50
+	reportMaxPageSize = 500
+)
+
+type Report struct {
+	Id         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ReporterID *uuid.UUID `json:"reporter_id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	ChirpID    *uuid.UUID `json:"chirp_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	Status     string     `json:"status"`
+	ResolvedBy *uuid.UUID `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+func uuidOrNil(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+func reportFromDB(r database.Report) Report {
+	return Report{
+		Id:         r.ID,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+		ReporterID: uuidOrNil(r.ReporterID),
+		UserID:     r.UserID,
+		ChirpID:    uuidOrNil(r.ChirpID),
+		Reason:     r.Reason,
+		Details:    r.Details,
+		Status:     r.Status,
+		ResolvedBy: uuidOrNil(r.ResolvedBy),
+		ResolvedAt: timeOrNil(r.ResolvedAt),
+		Resolution: r.Resolution.String,
+	}
+}
+
+type ModerationAction struct {
+	Id          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ModeratorID *uuid.UUID `json:"moderator_id"`
+	Action      string     `json:"action"`
+	ReportID    *uuid.UUID `json:"report_id,omitempty"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	ChirpID     *uuid.UUID `json:"chirp_id,omitempty"`
+	Reason      string     `json:"reason"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func moderationActionFromDB(a database.ModerationAction) ModerationAction {
+	return ModerationAction{
+		Id:          a.ID,
+		CreatedAt:   a.CreatedAt,
+		ModeratorID: uuidOrNil(a.ModeratorID),
+		Action:      a.Action,
+		ReportID:    uuidOrNil(a.ReportID),
+		UserID:      uuidOrNil(a.UserID),
+		ChirpID:     uuidOrNil(a.ChirpID),
+		Reason:      a.Reason,
+		ExpiresAt:   timeOrNil(a.ExpiresAt),
+	}
+}
+
+func reportPageSizeFromQuery(w http.ResponseWriter, req *http.Request) (int32, bool) {
+	pageSize := reportPageSize
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > reportMaxPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", reportMaxPageSize), err)
+			return 0, false
+		}
+		pageSize = n
+	}
+
+	return int32(pageSize), true
+}
+
+// handlerNewReport files a report about either a chirp or an account.
+func (cfg *apiConfig) handlerNewReport(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	type newReport struct {
+		ChirpID *uuid.UUID `json:"chirp_id"`
+		UserID  *uuid.UUID `json:"user_id"`
+		Reason  string     `json:"reason"`
+		Details string     `json:"details"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var r newReport
+	err := decoder.Decode(&r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if (r.ChirpID == nil) == (r.UserID == nil) {
+		respondWithError(w, http.StatusBadRequest, "report either a chirp_id or a user_id", nil)
+		return
+	}
+
+	err = moderation.CheckReport(r.Reason, r.Details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	params := database.CreateReportParams{
+		ReporterID: uuid.NullUUID{UUID: p.UserID, Valid: true},
+		Reason:     r.Reason,
+		Details:    r.Details,
+	}
+
+	if r.ChirpID != nil {
+		dbChirp, err := cfg.dbQueries.GetChirp(req.Context(), *r.ChirpID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "chirp not found", err)
+			return
+		}
+
+		visible, err := cfg.chirpVisibleTo(req.Context(), dbChirp, uuid.NullUUID{UUID: p.UserID, Valid: true})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to look up chirp author", err)
+			return
+		}
+		if !visible {
+			respondWithError(w, http.StatusNotFound, "chirp not found", nil)
+			return
+		}
+		params.UserID = dbChirp.UserID
+		params.ChirpID = uuid.NullUUID{UUID: dbChirp.ID, Valid: true}
+	} else {
+		dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), *r.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "user not found", err)
+			return
+		}
+		params.UserID = dbUser.ID
+	}
+
+	if params.UserID == p.UserID {
+		respondWithError(w, http.StatusBadRequest, "cannot report yourself", nil)
+		return
+	}
+
+	dbReport, err := cfg.dbQueries.CreateReport(req.Context(), params)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "already reported", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, reportFromDB(dbReport))
+}
+
+// handlerGetReports is the moderator queue, oldest first.
+func (cfg *apiConfig) handlerGetReports(w http.ResponseWriter, req *http.Request) {
+	pageSize, ok := reportPageSizeFromQuery(w, req)
+	if !ok {
+		return
+	}
+
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = reportStatusOpen
+	case reportStatusOpen, reportStatusDismissed, reportStatusActioned:
+	default:
+		respondWithError(w, http.StatusBadRequest, "unknown status", nil)
+		return
+	}
+
+	dbReports, err := cfg.dbQueries.ListReports(req.Context(), database.ListReportsParams{
+		Status:   status,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting reports from database", err)
+		return
+	}
+
+	reports := []Report{}
+	for _, item := range dbReports {
+		reports = append(reports, reportFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	reportID, err := uuid.Parse(req.PathValue("reportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	type decision struct {
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+		// Duration is a Go duration such as "72h", for suspensions.
+		Duration string `json:"duration"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var r decision
+	err = decoder.Decode(&r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	d := moderation.Decision{
+		Action: r.Action,
+		Reason: r.Reason,
+	}
+
+	if r.Duration != "" {
+		d.Duration, err = time.ParseDuration(r.Duration)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid duration", err)
+			return
+		}
+	}
+
+	dbReport, err := cfg.dbQueries.GetReport(req.Context(), reportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "report not found", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up report", err)
+		return
+	}
+
+	if dbReport.Status != reportStatusOpen {
+		respondWithError(w, http.StatusConflict, "report already resolved", nil)
+		return
+	}
+
+	err = d.Check(dbReport.ChirpID.Valid)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	err = cfg.resolveReport(req.Context(), p.UserID, dbReport, d)
+	if errors.Is(err, errStaffTarget) {
+		respondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "report already resolved", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to resolve report", err)
+		return
+	}
+
+	dbReport, err = cfg.dbQueries.GetReport(req.Context(), reportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reportFromDB(dbReport))
+}
+
+// handlerGetModerationActions is the audit log, newest first, optionally
+// for one user_id.
+func (cfg *apiConfig) handlerGetModerationActions(w http.ResponseWriter, req *http.Request) {
+	pageSize, ok := reportPageSizeFromQuery(w, req)
+	if !ok {
+		return
+	}
+
+	var userID uuid.NullUUID
+	if v := req.URL.Query().Get("user_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid user_id", err)
+			return
+		}
+		userID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	dbActions, err := cfg.dbQueries.ListModerationActions(req.Context(), database.ListModerationActionsParams{
+		UserID:   userID,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting audit log from database", err)
+		return
+	}
+
+	actions := []ModerationAction{}
+	for _, item := range dbActions {
+		actions = append(actions, moderationActionFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, actions)
+}
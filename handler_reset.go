@@ -2,6 +2,8 @@ package main
 
 import "net/http"
 
+// handlerResetMetrics wipes every user, admins included, so it is guarded
+// by the platform rather than by a role the caller would lose.
 func (cfg *apiConfig) handlerResetMetrics(w http.ResponseWriter, req *http.Request) {
 
 	if cfg.platform != "dev" {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	// Demoting yourself could leave nobody able to undo it.
+	if userID == p.UserID {
+		respondWithError(w, http.StatusBadRequest, "cannot change your own role", nil)
+		return
+	}
+
+	type roleChange struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var r roleChange
+	err = decoder.Decode(&r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if !auth.ValidRole(r.Role) {
+		respondWithError(w, http.StatusBadRequest, "unknown role", nil)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.UpdateUserRole(req.Context(), database.UpdateUserRoleParams{
+		Role: r.Role,
+		ID:   userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "user not found", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update role", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(dbUser))
+}
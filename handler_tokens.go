@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+type PersonalAccessToken struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func personalAccessTokenFromDB(t database.PersonalAccessToken) PersonalAccessToken {
+	pat := PersonalAccessToken{
+		Id:        t.ID,
+		Name:      t.Name,
+		Scopes:    auth.ParseScopes(t.Scope),
+		CreatedAt: t.CreatedAt,
+	}
+
+	if t.ExpiresAt.Valid {
+		pat.ExpiresAt = &t.ExpiresAt.Time
+	}
+
+	if t.LastUsedAt.Valid {
+		pat.LastUsedAt = &t.LastUsedAt.Time
+	}
+
+	return pat
+}
+
+// requireSession refuses requests made with a personal access token or an
+// OAuth app's token, so a leaked token cannot be used to mint or revoke
+// others.
+func requireSession(w http.ResponseWriter, p principal) bool {
+	if p.PersonalAccessTokenID.Valid {
+		respondWithError(w, http.StatusForbidden, "personal access tokens cannot manage tokens", nil)
+		return false
+	}
+
+	if p.ClientID.Valid {
+		respondWithError(w, http.StatusForbidden, "OAuth apps cannot manage tokens", nil)
+		return false
+	}
+
+	return true
+}
+
+func (cfg *apiConfig) handlerNewPersonalAccessToken(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type newToken struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var t newToken
+	err := decoder.Decode(&t)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if t.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+
+	if len(t.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+
+	scopes, ok := auth.NarrowScopes(p.Scopes, t.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid scope requested", nil)
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if t.ExpiresAt != nil {
+		if t.ExpiresAt.Before(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+			return
+		}
+		expiresAt = sql.NullTime{Time: *t.ExpiresAt, Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create token", err)
+		return
+	}
+
+	dbToken, err := cfg.dbQueries.CreatePersonalAccessToken(req.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    p.UserID,
+		Name:      t.Name,
+		TokenHash: auth.HashToken(token),
+		Scope:     auth.FormatScopes(scopes),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing token", err)
+		return
+	}
+
+	pat := personalAccessTokenFromDB(dbToken)
+	pat.Token = token
+
+	respondWithJSON(w, http.StatusCreated, pat)
+}
+
+func (cfg *apiConfig) handlerGetPersonalAccessTokens(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	dbTokens, err := cfg.dbQueries.ListPersonalAccessTokens(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting tokens from database", err)
+		return
+	}
+
+	tokens := []PersonalAccessToken{}
+
+	for _, item := range dbTokens {
+		tokens = append(tokens, personalAccessTokenFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlerRevokePersonalAccessToken(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	tokenID, err := uuid.Parse(req.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.RevokePersonalAccessToken(req.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: p.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke token", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "token not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+const (
+	totpIssuer         = "Chirpy"
+	recoveryCodeCount  = 10
+	twoFactorChallenge = 5 * time.Minute
+)
+
+func (cfg *apiConfig) respondWithTwoFactorChallenge(w http.ResponseWriter, dbUser database.User) {
+	challenge, err := auth.MakeJWT(dbUser.ID, cfg.secret, twoFactorChallenge, cfg.jwtOptions(auth.ScopeTwoFactorChallenge)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create challenge token", err)
+		return
+	}
+
+	type challengeResponse struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+		ExpiresIn         int    `json:"expires_in"`
+	}
+
+	respondWithJSON(w, http.StatusOK, challengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int(twoFactorChallenge.Seconds()),
+	})
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code. Both are single use.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, dbUser database.User, code string) (bool, error) {
+	if !dbUser.TotpSecret.Valid {
+		return false, nil
+	}
+
+	if step, ok := auth.ValidateTOTP(dbUser.TotpSecret.String, code, time.Now()); ok {
+		n, err := cfg.dbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			TotpLastStep: step,
+			ID:           dbUser.ID,
+		})
+		return n > 0, err
+	}
+
+	n, err := cfg.dbQueries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+		UserID:   dbUser.ID,
+		CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+	})
+	return n > 0, err
+}
+
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, req *http.Request) {
+	type twoFactorLogin struct {
+		ChallengeToken string   `json:"challenge_token"`
+		Code           string   `json:"code"`
+		Scopes         []string `json:"scopes"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var login twoFactorLogin
+	err := decoder.Decode(&login)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	claims, err := auth.ParseJWT(login.ChallengeToken, cfg.secret, cfg.jwtOptions()...)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	if !auth.HasScope(claims.Scopes, auth.ScopeTwoFactorChallenge) {
+		respondWithError(w, http.StatusUnauthorized, "not a challenge token", nil)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "user not found", err)
+		return
+	}
+
+	ip := clientIP(req)
+
+	if retryAfter, blocked := cfg.loginThrottle.blocked(dbUser.Email, ip); blocked {
+		respondWithTooManyAttempts(w, retryAfter)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(req.Context(), dbUser, login.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to check code", err)
+		return
+	}
+
+	if !ok {
+		cfg.loginThrottle.fail(dbUser.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "invalid code", nil)
+		return
+	}
+
+	cfg.loginThrottle.succeed(dbUser.Email)
+
+	scopes, ok := auth.NarrowScopes(cfg.allowedScopes(dbUser), login.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid scope requested", nil)
+		return
+	}
+
+	cfg.issueSession(w, req, dbUser, scopes)
+}
+
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "two-factor authentication already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to generate secret", err)
+		return
+	}
+
+	err = cfg.dbQueries.SetTOTPSecret(req.Context(), database.SetTOTPSecretParams{
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		ID:         dbUser.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing secret", err)
+		return
+	}
+
+	type enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	respondWithJSON(w, http.StatusOK, enrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, dbUser.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type confirmation struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var c confirmation
+	err := decoder.Decode(&c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "two-factor authentication already enabled", nil)
+		return
+	}
+
+	if !dbUser.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "two-factor enrollment not started", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(dbUser.TotpSecret.String, c.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "invalid code", nil)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to generate recovery codes", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to start transaction", err)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.DeleteRecoveryCodes(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to clear recovery codes", err)
+		return
+	}
+
+	for _, code := range codes {
+		err = qtx.CreateRecoveryCode(req.Context(), database.CreateRecoveryCodeParams{
+			UserID:   dbUser.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed storing recovery codes", err)
+			return
+		}
+	}
+
+	err = qtx.EnableTOTP(req.Context(), database.EnableTOTPParams{
+		TotpLastStep: step,
+		ID:           dbUser.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to enable two-factor authentication", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to commit", err)
+		return
+	}
+
+	type recoveryCodes struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodes{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type disable struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var d disable
+	err := decoder.Decode(&d)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+
+	if !dbUser.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "two-factor authentication not enabled", nil)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(req.Context(), dbUser, d.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to check code", err)
+		return
+	}
+
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "invalid code", nil)
+		return
+	}
+
+	err = cfg.dbQueries.DisableTOTP(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to disable two-factor authentication", err)
+		return
+	}
+
+	err = cfg.dbQueries.DeleteRecoveryCodes(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to clear recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+// ModeratedUser is a user as admins see them. It is never shown to the
+// user, who must not learn they are shadow banned.
+type ModeratedUser struct {
+	User
+	SuspendedUntil   *time.Time `json:"suspended_until"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	BannedAt         *time.Time `json:"banned_at"`
+	BanReason        string     `json:"ban_reason,omitempty"`
+	ShadowBannedAt   *time.Time `json:"shadow_banned_at"`
+}
+
+func moderatedUserFromDB(u database.User) ModeratedUser {
+	return ModeratedUser{
+		User:             userFromDB(u),
+		SuspendedUntil:   timeOrNil(u.SuspendedUntil),
+		SuspensionReason: u.SuspensionReason.String,
+		BannedAt:         timeOrNil(u.BannedAt),
+		BanReason:        u.BanReason.String,
+		ShadowBannedAt:   timeOrNil(u.ShadowBannedAt),
+	}
+}
+
+// respondWithModeration applies d to the user named in the path and
+// responds with their new state.
+func (cfg *apiConfig) respondWithModeration(w http.ResponseWriter, req *http.Request, d moderation.Decision) {
+	p := principalFromContext(req.Context())
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	dbUser, err := cfg.moderateUser(req.Context(), p.UserID, userID, d)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "user not found", nil)
+		return
+	}
+	if errors.Is(err, errStaffTarget) {
+		respondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, moderatedUserFromDB(dbUser))
+}
+
+func (cfg *apiConfig) handlerSuspendUser(w http.ResponseWriter, req *http.Request) {
+	type suspension struct {
+		// Duration is a Go duration such as "72h".
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var s suspension
+	err := decoder.Decode(&s)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	duration, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid duration", err)
+		return
+	}
+
+	d := moderation.Decision{
+		Action:   moderation.ActionSuspend,
+		Reason:   s.Reason,
+		Duration: duration,
+	}
+
+	err = d.Check(false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	cfg.respondWithModeration(w, req, d)
+}
+
+func (cfg *apiConfig) handlerLiftSuspension(w http.ResponseWriter, req *http.Request) {
+	cfg.respondWithModeration(w, req, moderation.Decision{Action: moderation.ActionLiftSuspension})
+}
+
+func (cfg *apiConfig) handlerShadowBanUser(w http.ResponseWriter, req *http.Request) {
+	type shadowBan struct {
+		Reason string `json:"reason"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var s shadowBan
+	err := decoder.Decode(&s)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	d := moderation.Decision{
+		Action: moderation.ActionShadowBan,
+		Reason: s.Reason,
+	}
+
+	err = d.Check(false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	cfg.respondWithModeration(w, req, d)
+}
+
+func (cfg *apiConfig) handlerLiftShadowBan(w http.ResponseWriter, req *http.Request) {
+	cfg.respondWithModeration(w, req, moderation.Decision{Action: moderation.ActionLiftShadowBan})
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -11,11 +14,54 @@ import (
 )
 
 type User struct {
-	Id          uuid.UUID `json:"id"`
-	Email       string    `json:"email"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Id                  uuid.UUID  `json:"id"`
+	Email               string     `json:"email"`
+	EmailVerified       bool       `json:"email_verified"`
+	PendingEmail        string     `json:"pending_email,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	IsChirpyRed         bool       `json:"is_chirpy_red"`
+	Role                string     `json:"role"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+func userFromDB(u database.User) User {
+	user := User{
+		Id:            u.ID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+		IsChirpyRed:   u.IsChirpyRed,
+		Role:          u.Role,
+	}
+
+	if u.DeletionScheduledAt.Valid {
+		user.DeletionScheduledAt = &u.DeletionScheduledAt.Time
+	}
+
+	return user
+}
+
+// checkPassword applies the password policy, responding with every rule
+// password breaks. It returns false if the password was rejected.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, field, password string) bool {
+	violations := cfg.passwordPolicy.Check(password)
+	if len(violations) == 0 {
+		return true
+	}
+
+	details := []fieldError{}
+	for _, v := range violations {
+		details = append(details, fieldError{
+			Field:   field,
+			Code:    v.Code,
+			Message: v.Message,
+		})
+	}
+
+	respondWithValidationError(w, "password does not meet requirements", details)
+	return false
 }
 
 func (cfg *apiConfig) handlerNewUser(w http.ResponseWriter, req *http.Request) {
@@ -34,6 +80,15 @@ func (cfg *apiConfig) handlerNewUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !validEmail(u.Email) {
+		respondWithError(w, http.StatusBadRequest, "invalid email address", nil)
+		return
+	}
+
+	if !cfg.checkPassword(w, "password", u.Password) {
+		return
+	}
+
 	hashed, err := auth.HashPassword(u.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed hashing password", err)
@@ -50,68 +105,170 @@ func (cfg *apiConfig) handlerNewUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, User{
-		Id:          dbUser.ID,
-		Email:       dbUser.Email,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		IsChirpyRed: dbUser.IsChirpyRed,
-	})
+	// The account exists either way. The user can ask for another link
+	// through /api/email/verify/resend.
+	err = cfg.sendEmailToken(req.Context(), dbUser.ID, dbUser.Email, emailPurposeVerify)
+	if err != nil {
+		log.Printf("failed to send verification email to user %s: %s", dbUser.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, userFromDB(dbUser))
 }
 
+// handlerUpdateUser replaces the email and password in one go. It is the
+// older form of PATCH /api/users/me and goes through the same checks.
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, req *http.Request) {
-	bearerToken, err := auth.GetBearerToken(req.Header)
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
+		return
+	}
+
+	type userUpdate struct {
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	update := userUpdate{}
+	err := decoder.Decode(&update)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "authorization not found", nil)
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(bearerToken, cfg.secret)
+	cfg.patchUser(w, req, p, userPatch{
+		Email:           &update.Email,
+		Password:        &update.Password,
+		CurrentPassword: update.CurrentPassword,
+	})
+}
+
+// requestEmailChange mails a confirmation link to email. The address on the
+// account only changes once that link is used. It responds and returns
+// false if the change cannot be started.
+func (cfg *apiConfig) requestEmailChange(w http.ResponseWriter, req *http.Request, dbUser database.User, email string) bool {
+	if !validEmail(email) {
+		respondWithError(w, http.StatusBadRequest, "invalid email address", nil)
+		return false
+	}
+
+	_, err := cfg.dbQueries.GetUserByEmail(req.Context(), email)
+	if err == nil {
+		respondWithError(w, http.StatusConflict, "email address already in use", nil)
+		return false
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up email", err)
+		return false
+	}
+
+	err = cfg.sendEmailToken(req.Context(), dbUser.ID, email, emailPurposeChange)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token not valid", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to send confirmation email", err)
+		return false
+	}
+
+	return true
+}
+
+type userPatch struct {
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+func (cfg *apiConfig) handlerPatchUser(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+	if !requireSession(w, p) {
 		return
 	}
 
-	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	decoder := json.NewDecoder(req.Body)
+	var patch userPatch
+	err := decoder.Decode(&patch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	cfg.patchUser(w, req, p, patch)
+}
+
+// patchUser applies the fields set in patch to the caller's account. A new
+// password needs the current one and signs out every other session.
+func (cfg *apiConfig) patchUser(w http.ResponseWriter, req *http.Request, p principal, patch userPatch) {
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), p.UserID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "user not found", err)
 		return
 	}
 
-	type userUpdate struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+	if patch.Password != nil {
+		_, err = auth.CheckPasswordHash(patch.CurrentPassword, dbUser.HashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "current password is incorrect", nil)
+			return
+		}
+
+		if !cfg.checkPassword(w, "password", *patch.Password) {
+			return
+		}
 	}
 
-	decoder := json.NewDecoder(req.Body)
-	update := userUpdate{}
-	err = decoder.Decode(&update)
+	pendingEmail := ""
+	if patch.Email != nil && *patch.Email != dbUser.Email {
+		if !cfg.requestEmailChange(w, req, dbUser, *patch.Email) {
+			return
+		}
+		pendingEmail = *patch.Email
+	}
+
+	if patch.Password == nil {
+		user := userFromDB(dbUser)
+		user.PendingEmail = pendingEmail
+
+		respondWithJSON(w, http.StatusOK, user)
+		return
+	}
+
+	hashed, err := auth.HashPassword(*patch.Password)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		respondWithError(w, http.StatusInternalServerError, "failed hashing password", err)
+		return
 	}
 
-	hashed, err := auth.HashPassword(update.Password)
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to start transaction", err)
 		return
 	}
+	defer tx.Rollback()
 
-	dbUpdate, err := cfg.dbQueries.UpdateUser(req.Context(), database.UpdateUserParams{
-		Email:          update.Email,
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.UpdatePassword(req.Context(), database.UpdatePasswordParams{
 		HashedPassword: hashed,
 		ID:             dbUser.ID,
 	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update password", err)
+		return
+	}
 
+	err = qtx.RevokeUserRefreshTokens(req.Context(), dbUser.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to update user", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to revoke sessions", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, User{
-		Id:          dbUpdate.ID,
-		CreatedAt:   dbUpdate.CreatedAt,
-		UpdatedAt:   dbUpdate.UpdatedAt,
-		Email:       dbUpdate.Email,
-		IsChirpyRed: dbUpdate.IsChirpyRed,
-	})
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to commit", err)
+		return
+	}
+
+	// Every other session is now revoked. Hand this client a fresh one so
+	// it stays signed in.
+	cfg.issueSession(w, req, dbUser, p.Scopes)
 }
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+)
+
+const (
+	webhookDeliveryPageSize    = 50
+	webhookDeliveryMaxPageSize = 500
+)
+
+type WebhookEndpoint struct {
+	Id        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	// Secret is only returned when the endpoint is created. Receivers use
+	// it to check the Webhook-Signature header.
+	Secret string `json:"secret,omitempty"`
+}
+
+func webhookEndpointFromDB(e database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		Id:        e.ID,
+		CreatedAt: e.CreatedAt,
+		URL:       e.Url,
+		Events:    strings.Fields(e.Events),
+	}
+}
+
+type WebhookDelivery struct {
+	Id             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus *int32          `json:"response_status"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+func webhookDeliveryFromDB(d database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		Id:            d.ID,
+		CreatedAt:     d.CreatedAt,
+		EventID:       d.EventID,
+		EventType:     d.EventType,
+		Payload:       d.Payload,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		LastAttemptAt: timeOrNil(d.LastAttemptAt),
+		LastError:     d.LastError.String,
+		DeliveredAt:   timeOrNil(d.DeliveredAt),
+	}
+
+	if d.Status == deliveryStatusPending {
+		delivery.NextAttemptAt = &d.NextAttemptAt
+	}
+
+	if d.ResponseStatus.Valid {
+		delivery.ResponseStatus = &d.ResponseStatus.Int32
+	}
+
+	return delivery
+}
+
+// validWebhookURL only allows https. Where the URL points is checked again
+// when connecting, so it cannot be aimed at our own network.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return false
+	}
+
+	return u.Scheme == "https"
+}
+
+func (cfg *apiConfig) handlerNewWebhookEndpoint(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	type newEndpoint struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var e newEndpoint
+	err := decoder.Decode(&e)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "JSON decode error", err)
+		return
+	}
+
+	if !validWebhookURL(e.URL) {
+		respondWithError(w, http.StatusBadRequest, "url must be an https url", nil)
+		return
+	}
+
+	if len(e.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one event is required", nil)
+		return
+	}
+
+	for _, event := range e.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			respondWithError(w, http.StatusBadRequest, "unknown event "+event, nil)
+			return
+		}
+	}
+
+	slices.Sort(e.Events)
+	e.Events = slices.Compact(e.Events)
+
+	secret, err := auth.MakeOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create webhook secret", err)
+		return
+	}
+
+	dbEndpoint, err := cfg.dbQueries.CreateWebhookEndpoint(req.Context(), database.CreateWebhookEndpointParams{
+		UserID: p.UserID,
+		Url:    e.URL,
+		Secret: secret,
+		Events: strings.Join(e.Events, " "),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed storing webhook", err)
+		return
+	}
+
+	endpoint := webhookEndpointFromDB(dbEndpoint)
+	endpoint.Secret = secret
+
+	respondWithJSON(w, http.StatusCreated, endpoint)
+}
+
+func (cfg *apiConfig) handlerGetWebhookEndpoints(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	dbEndpoints, err := cfg.dbQueries.ListWebhookEndpoints(req.Context(), p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting webhooks from database", err)
+		return
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, item := range dbEndpoints {
+		endpoints = append(endpoints, webhookEndpointFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, endpoints)
+}
+
+func (cfg *apiConfig) handlerDeleteWebhookEndpoint(w http.ResponseWriter, req *http.Request) {
+	p := principalFromContext(req.Context())
+
+	endpointID, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	n, err := cfg.dbQueries.DeleteWebhookEndpoint(req.Context(), database.DeleteWebhookEndpointParams{
+		ID:     endpointID,
+		UserID: p.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete webhook", err)
+		return
+	}
+
+	if n == 0 {
+		respondWithError(w, http.StatusNotFound, "webhook not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// ownedWebhookEndpoint looks up the endpoint named in the path, answering
+// 404 for other users' endpoints as well as missing ones.
+func (cfg *apiConfig) ownedWebhookEndpoint(w http.ResponseWriter, req *http.Request) (database.WebhookEndpoint, bool) {
+	p := principalFromContext(req.Context())
+
+	endpointID, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return database.WebhookEndpoint{}, false
+	}
+
+	dbEndpoint, err := cfg.dbQueries.GetWebhookEndpoint(req.Context(), endpointID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && dbEndpoint.UserID != p.UserID) {
+		respondWithError(w, http.StatusNotFound, "webhook not found", nil)
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up webhook", err)
+		return database.WebhookEndpoint{}, false
+	}
+
+	return dbEndpoint, true
+}
+
+func (cfg *apiConfig) handlerGetWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	dbEndpoint, ok := cfg.ownedWebhookEndpoint(w, req)
+	if !ok {
+		return
+	}
+
+	pageSize := webhookDeliveryPageSize
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > webhookDeliveryMaxPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", webhookDeliveryMaxPageSize), err)
+			return
+		}
+		pageSize = n
+	}
+
+	dbDeliveries, err := cfg.dbQueries.ListWebhookDeliveries(req.Context(), database.ListWebhookDeliveriesParams{
+		EndpointID: dbEndpoint.ID,
+		Limit:      int32(pageSize),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting deliveries from database", err)
+		return
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, item := range dbDeliveries {
+		deliveries = append(deliveries, webhookDeliveryFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// handlerRetryWebhookDelivery puts a dead-lettered delivery back in the
+// queue with a fresh set of attempts.
+func (cfg *apiConfig) handlerRetryWebhookDelivery(w http.ResponseWriter, req *http.Request) {
+	dbEndpoint, ok := cfg.ownedWebhookEndpoint(w, req)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(req.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	dbDelivery, err := cfg.dbQueries.RequeueWebhookDelivery(req.Context(), database.RequeueWebhookDeliveryParams{
+		ID:         deliveryID,
+		EndpointID: dbEndpoint.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "no dead delivery with that id", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to requeue delivery", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookDeliveryFromDB(dbDelivery))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+)
+
+const (
+	webhookStatusProcessing = "processing"
+	webhookStatusProcessed  = "processed"
+	webhookStatusIgnored    = "ignored"
+	webhookStatusFailed     = "failed"
+
+	webhookEventPageSize    = 50
+	webhookEventMaxPageSize = 500
+
+	// webhookEventLease is how long an event may sit in processing before
+	// it is presumed abandoned, say by a crash, and can be claimed again.
+	webhookEventLease = 10 * time.Minute
+)
+
+type WebhookEvent struct {
+	Id          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	Error       string          `json:"error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+}
+
+func webhookEventFromDB(e database.WebhookEvent) WebhookEvent {
+	event := WebhookEvent{
+		Id:        e.ID,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+		Provider:  e.Provider,
+		EventID:   e.EventID,
+		EventType: e.EventType,
+		Payload:   e.Payload,
+		Status:    e.Status,
+		Attempts:  e.Attempts,
+		Error:     e.Error.String,
+	}
+
+	if e.ProcessedAt.Valid {
+		event.ProcessedAt = &e.ProcessedAt.Time
+	}
+
+	return event
+}
+
+// claimWebhookEvent records an incoming event, or takes back one that
+// failed earlier, or whose processing lease has run out, for another
+// attempt. It returns false when the event has already been handled, or is
+// being handled by another request, and the delivery should be
+// acknowledged without doing anything.
+func (cfg *apiConfig) claimWebhookEvent(ctx context.Context, provider, eventID, eventType string, payload []byte) (database.WebhookEvent, bool, error) {
+	event, err := cfg.dbQueries.CreateWebhookEvent(ctx, database.CreateWebhookEventParams{
+		Provider:  provider,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+	})
+	if err == nil {
+		return event, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.WebhookEvent{}, false, err
+	}
+
+	event, err = cfg.dbQueries.RetryWebhookEvent(ctx, database.RetryWebhookEventParams{
+		Provider:    provider,
+		EventID:     eventID,
+		StaleBefore: time.Now().Add(-webhookEventLease),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.WebhookEvent{}, false, nil
+	}
+	if err != nil {
+		return database.WebhookEvent{}, false, err
+	}
+
+	return event, true, nil
+}
+
+// processWebhookEvent applies a claimed event and records the outcome.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) error {
+	var ignored bool
+	var err error
+
+	provider, ok := cfg.billingProviders[event.Provider]
+	if ok {
+		ignored, err = cfg.processBillingEvent(ctx, provider, event.Payload)
+	} else {
+		err = fmt.Errorf("unknown webhook provider %q", event.Provider)
+	}
+
+	if err != nil {
+		failErr := cfg.dbQueries.FailWebhookEvent(ctx, database.FailWebhookEventParams{
+			Error: sql.NullString{String: err.Error(), Valid: true},
+			ID:    event.ID,
+		})
+		if failErr != nil {
+			log.Printf("failed recording webhook event %s failure: %s", event.ID, failErr)
+		}
+		return err
+	}
+
+	status := webhookStatusProcessed
+	if ignored {
+		status = webhookStatusIgnored
+	}
+
+	return cfg.dbQueries.CompleteWebhookEvent(ctx, database.CompleteWebhookEventParams{
+		Status: status,
+		ID:     event.ID,
+	})
+}
+
+func (cfg *apiConfig) handlerGetWebhookEvents(w http.ResponseWriter, req *http.Request) {
+	pageSize := webhookEventPageSize
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > webhookEventMaxPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", webhookEventMaxPageSize), err)
+			return
+		}
+		pageSize = n
+	}
+
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", webhookStatusProcessing, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "unknown status", nil)
+		return
+	}
+
+	dbEvents, err := cfg.dbQueries.ListWebhookEvents(req.Context(), database.ListWebhookEventsParams{
+		Status:   status,
+		PageSize: int32(pageSize),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed getting webhook events from database", err)
+		return
+	}
+
+	events := []WebhookEvent{}
+	for _, item := range dbEvents {
+		events = append(events, webhookEventFromDB(item))
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// handlerReplayWebhookEvent runs a failed or abandoned event again from its
+// stored payload. The response carries the event's new status, which is failed
+// again if the replay did not succeed.
+func (cfg *apiConfig) handlerReplayWebhookEvent(w http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid uuid", err)
+		return
+	}
+
+	event, err := cfg.dbQueries.RetryWebhookEventByID(req.Context(), database.RetryWebhookEventByIDParams{
+		ID:          id,
+		StaleBefore: time.Now().Add(-webhookEventLease),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.dbQueries.GetWebhookEvent(req.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "webhook event not found", nil)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to look up webhook event", err)
+			return
+		}
+		respondWithError(w, http.StatusConflict, "only failed or abandoned events can be replayed", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to claim webhook event", err)
+		return
+	}
+
+	err = cfg.processWebhookEvent(req.Context(), event)
+	if err != nil {
+		log.Printf("replaying webhook event %s failed: %s", event.ID, err)
+	}
+
+	event, err = cfg.dbQueries.GetWebhookEvent(req.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to look up webhook event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,33 +11,130 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+const DefaultIssuer = "chirpy"
 
-	if err != nil {
-		return "", err
+var (
+	ErrNoToken          = errors.New("authorization not found")
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrBadSignature     = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token expired")
+	ErrNotYetValid      = errors.New("token not valid yet")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrMalformedSubject = errors.New("malformed token subject")
+	ErrUnknownToken     = errors.New("unknown token")
+	ErrRevoked          = errors.New("token revoked")
+)
+
+type jwtOptions struct {
+	issuer   string
+	audience string
+	leeway   time.Duration
+	scopes   []string
+	role     string
+	clientID uuid.NullUUID
+}
+
+// JWTOption configures how tokens are minted by MakeJWT and checked by
+// ValidateJWT. The same options should be passed to both.
+type JWTOption func(*jwtOptions)
+
+func WithIssuer(issuer string) JWTOption {
+	return func(o *jwtOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim on new tokens and requires it on
+// validation. An empty audience disables the check.
+func WithAudience(audience string) JWTOption {
+	return func(o *jwtOptions) {
+		o.audience = audience
+	}
+}
+
+// WithLeeway allows for clock skew when checking exp, nbf and iat.
+func WithLeeway(leeway time.Duration) JWTOption {
+	return func(o *jwtOptions) {
+		o.leeway = leeway
+	}
+}
+
+// WithScopes sets the scope claim on new tokens. It has no effect on
+// validation; use HasScope on the parsed claims instead.
+func WithScopes(scopes ...string) JWTOption {
+	return func(o *jwtOptions) {
+		o.scopes = scopes
 	}
+}
+
+// WithRole sets the role claim on new tokens. Tokens without one are
+// treated as RoleUser.
+func WithRole(role string) JWTOption {
+	return func(o *jwtOptions) {
+		o.role = role
+	}
+}
+
+// WithClientID marks new tokens as issued to an OAuth client rather than
+// to the user's own session.
+func WithClientID(clientID uuid.UUID) JWTOption {
+	return func(o *jwtOptions) {
+		o.clientID = uuid.NullUUID{UUID: clientID, Valid: true}
+	}
+}
+
+// Claims is the validated content of an access token.
+type Claims struct {
+	UserID    uuid.UUID
+	Scopes    []string
+	Role      string
+	ClientID  uuid.NullUUID
+	ExpiresAt time.Time
+}
 
-	return string(hashed), nil
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope,omitempty"`
+	Role     string `json:"role,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+func newJWTOptions(opts []JWTOption) jwtOptions {
+	o := jwtOptions{issuer: DefaultIssuer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, opts ...JWTOption) (string, error) {
+	o := newJWTOptions(opts)
 	issueTime := jwt.NewNumericDate(time.Now())
 	expireTime := jwt.NewNumericDate(issueTime.Add(expiresIn))
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  issueTime,
-		ExpiresAt: expireTime,
-		Subject:   userID.String(),
-	})
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    o.issuer,
+			IssuedAt:  issueTime,
+			ExpiresAt: expireTime,
+			Subject:   userID.String(),
+		},
+		Scope: FormatScopes(o.scopes),
+		Role:  o.role,
+	}
+
+	if o.clientID.Valid {
+		claims.ClientID = o.clientID.UUID.String()
+	}
+
+	if o.audience != "" {
+		claims.Audience = jwt.ClaimStrings{o.audience}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	signed, err := token.SignedString([]byte(tokenSecret))
 
@@ -48,20 +146,92 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(t *jwt.Token) (interface{}, error) {
+func ValidateJWT(tokenString, tokenSecret string, opts ...JWTOption) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret, opts...)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return claims.UserID, nil
+}
+
+func ParseJWT(tokenString, tokenSecret string, opts ...JWTOption) (Claims, error) {
+	o := newJWTOptions(opts)
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(o.issuer),
+		jwt.WithLeeway(o.leeway),
+	}
+
+	if o.audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(o.audience))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &tokenClaims{}, func(t *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, parserOpts...)
 
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, classifyJWTError(err)
 	}
 
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok {
-		return uuid.MustParse(claims.Subject), nil
+	claims, ok := token.Claims.(*tokenClaims)
+	if !ok {
+		return Claims{}, fmt.Errorf("unknown claims type")
 	}
 
-	return uuid.Nil, fmt.Errorf("unknown claims type")
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrMalformedSubject, err)
+	}
+
+	role := claims.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	var clientID uuid.NullUUID
+	if claims.ClientID != "" {
+		clientID.UUID, err = uuid.Parse(claims.ClientID)
+		if err != nil {
+			return Claims{}, fmt.Errorf("%w: client_id: %w", ErrMalformedToken, err)
+		}
+		clientID.Valid = true
+	}
+
+	return Claims{
+		UserID:    userID,
+		Scopes:    ParseScopes(claims.Scope),
+		Role:      role,
+		ClientID:  clientID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// classifyJWTError maps the jwt library's errors onto our sentinels so
+// callers can use errors.Is without depending on the library.
+func classifyJWTError(err error) error {
+	var sentinel error
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		sentinel = ErrExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		sentinel = ErrNotYetValid
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		sentinel = ErrBadSignature
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		sentinel = ErrInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		sentinel = ErrInvalidAudience
+	default:
+		sentinel = ErrMalformedToken
+	}
+
+	return fmt.Errorf("%w: %w", sentinel, err)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -69,10 +239,16 @@ func GetBearerToken(headers http.Header) (string, error) {
 		return strings.TrimPrefix(value, "Bearer "), nil
 	}
 
-	return "", fmt.Errorf("authorization not found")
+	return "", ErrNoToken
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeOpaqueToken()
+}
+
+// MakeOpaqueToken returns 256 random bits, hex encoded, for single use
+// tokens such as password reset links.
+func MakeOpaqueToken() (string, error) {
 	b := make([]byte, 32)
 
 	_, err := rand.Read(b)
@@ -83,11 +259,3 @@ func MakeRefreshToken() (string, error) {
 
 	return hex.EncodeToString(b), nil
 }
-
-func GetAPIKey(headers http.Header) (string, error) {
-	if value := headers.Get("Authorization"); value != "" {
-		return strings.TrimPrefix(value, "ApiKey "), nil
-	}
-
-	return "", fmt.Errorf("apikey not found")
-}
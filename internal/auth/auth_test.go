@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -18,7 +20,7 @@ func TestHashPassword(t *testing.T) {
 		t.Fatalf("failed hashing password %v", err)
 	}
 
-	err = CheckPasswordHash(p, h)
+	_, err = CheckPasswordHash(p, h)
 
 	if err != nil {
 		t.Fatalf("Password doesn't match hashed value.")
@@ -55,6 +57,143 @@ func TestValidateJWT(t *testing.T) {
 
 }
 
+func TestValidateJWTExpired(t *testing.T) {
+	secret := "donthackmebro"
+
+	token, err := MakeJWT(uuid.New(), secret, -time.Minute)
+
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret)
+
+	if !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestValidateJWTLeeway(t *testing.T) {
+	secret := "donthackmebro"
+
+	token, err := MakeJWT(uuid.New(), secret, -time.Second*5)
+
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret, WithLeeway(time.Minute))
+
+	if err != nil {
+		t.Fatalf("expected token within leeway to validate: %v", err)
+	}
+}
+
+func TestValidateJWTBadSignature(t *testing.T) {
+	token, err := MakeJWT(uuid.New(), "donthackmebro", time.Minute)
+
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	_, err = ValidateJWT(token, "wrongsecret")
+
+	if !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+}
+
+func TestValidateJWTIssuerAndAudience(t *testing.T) {
+	secret := "donthackmebro"
+
+	token, err := MakeJWT(uuid.New(), secret, time.Minute, WithIssuer("other"), WithAudience("chirpy-api"))
+
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret, WithAudience("chirpy-api"))
+
+	if !errors.Is(err, ErrInvalidIssuer) {
+		t.Fatalf("expected ErrInvalidIssuer, got %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret, WithIssuer("other"), WithAudience("someone-else"))
+
+	if !errors.Is(err, ErrInvalidAudience) {
+		t.Fatalf("expected ErrInvalidAudience, got %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret, WithIssuer("other"), WithAudience("chirpy-api"))
+
+	if err != nil {
+		t.Fatalf("failed to validate jwt %v", err)
+	}
+}
+
+func TestValidateJWTMalformedSubject(t *testing.T) {
+	secret := "donthackmebro"
+	now := time.Now()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    DefaultIssuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		Subject:   "not-a-uuid",
+	}).SignedString([]byte(secret))
+
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	_, err = ValidateJWT(token, secret)
+
+	if !errors.Is(err, ErrMalformedSubject) {
+		t.Fatalf("expected ErrMalformedSubject, got %v", err)
+	}
+}
+
+func TestParseJWTClientID(t *testing.T) {
+	secret := "donthackmebro"
+	clientID := uuid.New()
+
+	token, err := MakeJWT(uuid.New(), secret, time.Minute, WithClientID(clientID))
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	claims, err := ParseJWT(token, secret)
+	if err != nil {
+		t.Fatalf("failed to parse jwt %v", err)
+	}
+
+	if !claims.ClientID.Valid || claims.ClientID.UUID != clientID {
+		t.Fatalf("expected client_id %v, got %v", clientID, claims.ClientID)
+	}
+
+	token, err = MakeJWT(uuid.New(), secret, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	claims, err = ParseJWT(token, secret)
+	if err != nil {
+		t.Fatalf("failed to parse jwt %v", err)
+	}
+
+	if claims.ClientID.Valid {
+		t.Fatalf("expected no client_id, got %v", claims.ClientID)
+	}
+}
+
+func TestValidateJWTMalformed(t *testing.T) {
+	_, err := ValidateJWT("not.a.jwt", "donthackmebro")
+
+	if !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf("expected ErrMalformedToken, got %v", err)
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	header := http.Header{}
 
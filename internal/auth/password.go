@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch  = errors.New("password does not match")
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+)
+
+// PasswordHasher is one password hash format. Hashes are self describing,
+// so several formats can be verified side by side while only the current
+// one is used for new hashes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Identify reports whether hash is in this hasher's format.
+	Identify(hash string) bool
+	// Verify returns ErrPasswordMismatch if password does not match.
+	Verify(password, hash string) error
+	// NeedsRehash reports whether hash was made with weaker settings than
+	// this hasher currently uses.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	hashersMu     sync.RWMutex
+	currentHasher PasswordHasher = Argon2idHasher{Params: DefaultArgon2Params}
+	legacyHashers                = []PasswordHasher{BcryptHasher{Cost: bcrypt.DefaultCost}}
+)
+
+// SetPasswordHashers changes the format used for new hashes. legacy lists
+// the other formats that are still accepted on login.
+func SetPasswordHashers(current PasswordHasher, legacy ...PasswordHasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+
+	currentHasher = current
+	legacyHashers = legacy
+}
+
+func HashPassword(password string) (string, error) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	return currentHasher.Hash(password)
+}
+
+// CheckPasswordHash verifies password against hash in any known format.
+// needsRehash is true when the password matched but hash should be
+// replaced with a fresh HashPassword.
+func CheckPasswordHash(password, hash string) (needsRehash bool, err error) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	if currentHasher.Identify(hash) {
+		err := currentHasher.Verify(password, hash)
+		if err != nil {
+			return false, err
+		}
+		return currentHasher.NeedsRehash(hash), nil
+	}
+
+	for _, h := range legacyHashers {
+		if h.Identify(hash) {
+			err := h.Verify(password, hash)
+			if err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+
+	return false, ErrUnknownHashFormat
+}
+
+var dummyHash = sync.OnceValue(func() string {
+	hashed, _ := HashPassword("chirpy-dummy-password")
+	return hashed
+})
+
+// DummyCheckPassword takes as long as a real CheckPasswordHash. Call it
+// when there is no user to check against so response times do not reveal
+// which accounts exist.
+func DummyCheckPassword(password string) {
+	CheckPasswordHash(password, dummyHash())
+}
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for Argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var b64 = base64.RawStdEncoding
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Params.Memory,
+		h.Params.Iterations,
+		h.Params.Parallelism,
+		b64.EncodeToString(salt),
+		b64.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (h Argon2idHasher) Verify(password, hash string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Params.Memory ||
+		params.Iterations < h.Params.Iterations ||
+		params.Parallelism < h.Params.Parallelism ||
+		params.SaltLength < h.Params.SaltLength ||
+		params.KeyLength < h.Params.KeyLength
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var params Argon2Params
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher verifies the hashes Chirpy stored before Argon2id. Bcrypt
+// ignores anything past 72 bytes of password, which is why it is no longer
+// used for new hashes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (h BcryptHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Verify(password, hash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+
+	return err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
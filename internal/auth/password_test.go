@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := Argon2idHasher{Params: testArgon2Params}
+
+	hashed, err := h.Hash("purified_water")
+
+	if err != nil {
+		t.Fatalf("failed hashing password %v", err)
+	}
+
+	if !strings.HasPrefix(hashed, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format %s", hashed)
+	}
+
+	if err := h.Verify("purified_water", hashed); err != nil {
+		t.Fatalf("password doesn't match hashed value: %v", err)
+	}
+
+	if err := h.Verify("tap_water", hashed); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("expected ErrPasswordMismatch, got %v", err)
+	}
+
+	if h.NeedsRehash(hashed) {
+		t.Fatalf("fresh hash reported as needing rehash")
+	}
+
+	stronger := Argon2idHasher{Params: testArgon2Params}
+	stronger.Params.Iterations = 2
+
+	if !stronger.NeedsRehash(hashed) {
+		t.Fatalf("weaker hash not reported as needing rehash")
+	}
+}
+
+func TestArgon2idLongPasswords(t *testing.T) {
+	h := Argon2idHasher{Params: testArgon2Params}
+	prefix := strings.Repeat("a", 72)
+
+	hashed, err := h.Hash(prefix + "1")
+
+	if err != nil {
+		t.Fatalf("failed hashing password %v", err)
+	}
+
+	if err := h.Verify(prefix+"2", hashed); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("passwords differing after 72 bytes matched")
+	}
+}
+
+func TestCheckPasswordHashLegacyBcrypt(t *testing.T) {
+	SetPasswordHashers(Argon2idHasher{Params: testArgon2Params}, BcryptHasher{Cost: bcrypt.MinCost})
+	defer SetPasswordHashers(Argon2idHasher{Params: DefaultArgon2Params}, BcryptHasher{Cost: bcrypt.DefaultCost})
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("purified_water"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatalf("failed hashing password %v", err)
+	}
+
+	needsRehash, err := CheckPasswordHash("purified_water", string(legacy))
+
+	if err != nil {
+		t.Fatalf("legacy hash rejected: %v", err)
+	}
+
+	if !needsRehash {
+		t.Fatalf("legacy hash not reported as needing rehash")
+	}
+
+	if _, err := CheckPasswordHash("tap_water", string(legacy)); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("expected ErrPasswordMismatch, got %v", err)
+	}
+
+	current, err := HashPassword("purified_water")
+
+	if err != nil {
+		t.Fatalf("failed hashing password %v", err)
+	}
+
+	needsRehash, err = CheckPasswordHash("purified_water", current)
+
+	if err != nil || needsRehash {
+		t.Fatalf("current hash: needsRehash=%v err=%v", needsRehash, err)
+	}
+
+	if _, err := CheckPasswordHash("purified_water", "unset"); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Fatalf("expected ErrUnknownHashFormat, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package auth
+
+import "testing"
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	if !IsPersonalAccessToken(token) {
+		t.Fatalf("token missing prefix %s", token)
+	}
+
+	if IsPersonalAccessToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Fatalf("jwt mistaken for personal access token")
+	}
+
+	if HashToken(token) != HashToken(token) || HashToken(token) == token {
+		t.Fatalf("unexpected token hash")
+	}
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// VerifyPKCE checks an OAuth code verifier against the S256 challenge sent
+// with the authorization request (RFC 7636).
+func VerifyPKCE(verifier, challenge string) bool {
+	// The RFC requires 43 to 128 characters, which also rules out an
+	// empty verifier.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,21 @@
+package auth
+
+import "testing"
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !VerifyPKCE(verifier, challenge) {
+		t.Fatalf("RFC 7636 example verifier rejected")
+	}
+
+	if VerifyPKCE(verifier[:42]+"x", challenge) {
+		t.Fatalf("wrong verifier accepted")
+	}
+
+	if VerifyPKCE("", "") {
+		t.Fatalf("empty verifier accepted")
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func violationCodes(v []PolicyViolation) string {
+	codes := []string{}
+	for _, item := range v {
+		codes = append(codes, item.Code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:  8,
+		MaxLength:  16,
+		MinClasses: 3,
+	}
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"", "too_short,too_simple"},
+		{"short1A", "too_short"},
+		{"alllowercase", "too_simple"},
+		{"Mixed-Case1", ""},
+		{"Ünïcödé-Päss1", ""},
+		{"ThisIsWayTooLong1", "too_long"},
+	}
+
+	for _, tt := range tests {
+		got := violationCodes(policy.Check(tt.password))
+		if got != tt.want {
+			t.Fatalf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+		}
+	}
+
+	if v := (PasswordPolicy{}).Check(""); v != nil {
+		t.Fatalf("zero policy rejected empty password: %v", v)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" and "123456".
+	list := `# test list
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493
+7c4a8d09ca3762af61e59520943dc26494f8941b
+
+`
+
+	b, err := LoadBreachedPasswords(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("failed loading list: %v", err)
+	}
+
+	if b.Len() != 2 {
+		t.Fatalf("expected 2 hashes, got %d", b.Len())
+	}
+
+	if !b.Contains("password") || !b.Contains("123456") {
+		t.Fatalf("listed password not found")
+	}
+
+	if b.Contains("correct horse battery staple") {
+		t.Fatalf("unlisted password found")
+	}
+
+	if got := b.Range("5baa6"); len(got) != 1 || got[0] != "1E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+		t.Fatalf("unexpected range %v", got)
+	}
+
+	policy := PasswordPolicy{Breached: b}
+	if got := violationCodes(policy.Check("password")); got != "breached" {
+		t.Fatalf("expected breached, got %q", got)
+	}
+}
+
+func TestLoadBreachedPasswordsInvalid(t *testing.T) {
+	_, err := LoadBreachedPasswords(strings.NewReader("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot-a-hash\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
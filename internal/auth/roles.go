@@ -0,0 +1,50 @@
+package auth
+
+import "slices"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roles is ordered from least to most privileged; each role can do
+// everything the ones before it can.
+var roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func ValidRole(role string) bool {
+	return slices.Contains(roles, role)
+}
+
+// HasRole reports whether role is at least min. Unknown roles, including
+// the empty one, rank as RoleUser.
+func HasRole(role, min string) bool {
+	return max(slices.Index(roles, role), 0) >= slices.Index(roles, min)
+}
+
+// RoleScope is the scope a token needs, on top of the role itself, to use
+// routes reserved for role. It lets a token be narrowed so it cannot act
+// with the user's full privileges.
+func RoleScope(role string) string {
+	switch role {
+	case RoleAdmin:
+		return ScopeAdmin
+	case RoleModerator:
+		return ScopeModerate
+	}
+	return ""
+}
+
+// RoleScopes is the widest set of scopes a user with role may be granted.
+func RoleScopes(role string) []string {
+	scopes := slices.Clone(DefaultUserScopes)
+
+	if HasRole(role, RoleModerator) {
+		scopes = append(scopes, ScopeModerate)
+	}
+	if HasRole(role, RoleAdmin) {
+		scopes = append(scopes, ScopeAdmin)
+	}
+
+	return scopes
+}
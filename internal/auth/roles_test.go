@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, min string
+		want      bool
+	}{
+		{RoleUser, RoleUser, true},
+		{RoleUser, RoleModerator, false},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleUser, true},
+		{"", RoleModerator, false},
+		{"root", RoleAdmin, false},
+	}
+
+	for _, tt := range tests {
+		if got := HasRole(tt.role, tt.min); got != tt.want {
+			t.Fatalf("HasRole(%q, %q) = %v, want %v", tt.role, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestRoleScopes(t *testing.T) {
+	if slices.Contains(RoleScopes(RoleUser), ScopeModerate) || slices.Contains(RoleScopes(RoleUser), ScopeAdmin) {
+		t.Fatalf("user granted elevated scopes %v", RoleScopes(RoleUser))
+	}
+
+	moderator := RoleScopes(RoleModerator)
+	if !HasScope(moderator, ScopeModerate) || HasScope(moderator, ScopeAdmin) {
+		t.Fatalf("unexpected moderator scopes %v", moderator)
+	}
+
+	admin := RoleScopes(RoleAdmin)
+	if !HasScope(admin, ScopeModerate) || !HasScope(admin, ScopeAdmin) {
+		t.Fatalf("unexpected admin scopes %v", admin)
+	}
+}
+
+func TestJWTRole(t *testing.T) {
+	secret := "donthackmebro"
+
+	token, err := MakeJWT(uuid.New(), secret, time.Minute, WithRole(RoleModerator))
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	claims, err := ParseJWT(token, secret)
+	if err != nil {
+		t.Fatalf("failed to parse jwt %v", err)
+	}
+
+	if claims.Role != RoleModerator {
+		t.Fatalf("expected role %q, got %q", RoleModerator, claims.Role)
+	}
+
+	token, err = MakeJWT(uuid.New(), secret, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	claims, err = ParseJWT(token, secret)
+	if err != nil {
+		t.Fatalf("failed to parse jwt %v", err)
+	}
+
+	if claims.Role != RoleUser {
+		t.Fatalf("expected tokens without a role claim to be %q, got %q", RoleUser, claims.Role)
+	}
+}
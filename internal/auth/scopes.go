@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeChirpsDelete = "chirps:delete"
+	ScopeProfileWrite = "profile:write"
+	ScopeModerate     = "moderate"
+	ScopeAdmin        = "admin"
+
+	// ScopeTwoFactorChallenge is carried only by the short lived token
+	// handed out between the password and second factor steps of login.
+	ScopeTwoFactorChallenge = "2fa:challenge"
+)
+
+// DefaultUserScopes are granted to a regular account that logs in without
+// asking for anything narrower.
+var DefaultUserScopes = []string{
+	ScopeChirpsWrite,
+	ScopeChirpsDelete,
+	ScopeProfileWrite,
+}
+
+// ParseScopes splits a space separated scope string, as used in the JWT
+// scope claim and in storage.
+func ParseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+func FormatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func HasScope(granted []string, scope string) bool {
+	return slices.Contains(granted, scope)
+}
+
+// NarrowScopes returns requested if every entry is in allowed, or allowed
+// when nothing was requested. ok is false if a scope was not allowed.
+func NarrowScopes(allowed, requested []string) (scopes []string, ok bool) {
+	if len(requested) == 0 {
+		return allowed, true
+	}
+
+	for _, s := range requested {
+		if !HasScope(allowed, s) {
+			return nil, false
+		}
+	}
+
+	return requested, true
+}
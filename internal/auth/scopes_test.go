@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestJWTScopes(t *testing.T) {
+	userID := uuid.New()
+	secret := "donthackmebro"
+
+	token, err := MakeJWT(userID, secret, time.Minute, WithScopes(ScopeChirpsWrite))
+
+	if err != nil {
+		t.Fatalf("failed to create JWT: %v", err)
+	}
+
+	claims, err := ParseJWT(token, secret)
+
+	if err != nil {
+		t.Fatalf("failed to parse jwt %v", err)
+	}
+
+	if claims.UserID != userID {
+		t.Fatalf("expected subject %s, got %s", userID, claims.UserID)
+	}
+
+	if !HasScope(claims.Scopes, ScopeChirpsWrite) || HasScope(claims.Scopes, ScopeChirpsDelete) {
+		t.Fatalf("unexpected scopes %v", claims.Scopes)
+	}
+}
+
+func TestNarrowScopes(t *testing.T) {
+	scopes, ok := NarrowScopes(DefaultUserScopes, nil)
+
+	if !ok || !slices.Equal(scopes, DefaultUserScopes) {
+		t.Fatalf("expected default scopes, got %v", scopes)
+	}
+
+	scopes, ok = NarrowScopes(DefaultUserScopes, []string{ScopeChirpsWrite})
+
+	if !ok || !slices.Equal(scopes, []string{ScopeChirpsWrite}) {
+		t.Fatalf("expected narrowed scopes, got %v", scopes)
+	}
+
+	_, ok = NarrowScopes(DefaultUserScopes, []string{ScopeAdmin})
+
+	if ok {
+		t.Fatalf("expected admin scope to be refused")
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// SHA1 test vectors from RFC 6238 appendix B, truncated to six digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+
+		if err != nil {
+			t.Fatalf("failed to compute code: %v", err)
+		}
+
+		if got != want {
+			t.Fatalf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+
+	if err != nil {
+		t.Fatalf("failed to generate secret: %v", err)
+	}
+
+	now := time.Now()
+	code, err := TOTPCode(secret, TOTPStep(now.Add(-30*time.Second)))
+
+	if err != nil {
+		t.Fatalf("failed to compute code: %v", err)
+	}
+
+	step, ok := ValidateTOTP(secret, code, now)
+
+	if !ok || step != TOTPStep(now)-1 {
+		t.Fatalf("expected previous step code to validate")
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now.Add(5*time.Minute)); ok {
+		t.Fatalf("stale code validated")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "walt@example.com", "ABC")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:walt@example.com?") || !strings.Contains(uri, "secret=ABC") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+
+	if err != nil {
+		t.Fatalf("failed to generate codes: %v", err)
+	}
+
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes, got %d", len(codes))
+	}
+
+	if NormalizeRecoveryCode(strings.ToUpper(codes[0])) != strings.ReplaceAll(codes[0], "-", "") {
+		t.Fatalf("normalization mismatch for %s", codes[0])
+	}
+}
@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/webhook"
+)
+
+var polkaEvents = map[string]EventType{
+	"user.upgraded":               EventActivated,
+	"user.downgraded":             EventDowngraded,
+	"subscription.canceled":       EventCanceled,
+	"subscription.payment_failed": EventPaymentFailed,
+}
+
+// Polka verifies signed webhooks when a Verifier is set, and otherwise
+// falls back to the static ApiKey in the Authorization header.
+type Polka struct {
+	Verifier *webhook.Verifier
+	APIKey   string
+}
+
+func (p *Polka) Name() string {
+	return "polka"
+}
+
+func (p *Polka) Verify(header http.Header, body []byte) (string, error) {
+	if p.Verifier != nil {
+		return p.Verifier.Verify(header, body)
+	}
+
+	apiKey, ok := strings.CutPrefix(header.Get("Authorization"), "ApiKey ")
+	if !ok || p.APIKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(p.APIKey)) != 1 {
+		return "", ErrUnauthenticated
+	}
+
+	return "", nil
+}
+
+// Forget lets a signed event through again after it failed to process.
+func (p *Polka) Forget(eventID string) {
+	if p.Verifier != nil {
+		p.Verifier.Forget(eventID)
+	}
+}
+
+func (p *Polka) Parse(body []byte) (Notification, error) {
+	var polka struct {
+		Event string `json:"event"`
+		Data  struct {
+			UserID           string     `json:"user_id"`
+			Plan             string     `json:"plan"`
+			CurrentPeriodEnd *time.Time `json:"current_period_end"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal(body, &polka)
+	if err != nil {
+		return Notification{}, fmt.Errorf("%w: %w", ErrInvalidNotification, err)
+	}
+
+	n := Notification{Type: polka.Event}
+
+	eventType, ok := polkaEvents[polka.Event]
+	if !ok {
+		return n, nil
+	}
+
+	n.UserID, err = uuid.Parse(polka.Data.UserID)
+	if err != nil {
+		return Notification{}, fmt.Errorf("%w: invalid user_id: %w", ErrInvalidNotification, err)
+	}
+
+	n.Event = Event{
+		Type: eventType,
+		Plan: polka.Data.Plan,
+	}
+	if polka.Data.CurrentPeriodEnd != nil {
+		n.Event.PeriodEnd = *polka.Data.CurrentPeriodEnd
+	}
+
+	return n, nil
+}
@@ -0,0 +1,99 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/webhook"
+)
+
+func TestPolkaAPIKey(t *testing.T) {
+	p := &Polka{APIKey: "f271c81ff7084ee5b99a5091b42d486e"}
+
+	h := http.Header{}
+	h.Set("Authorization", "ApiKey f271c81ff7084ee5b99a5091b42d486e")
+	if _, err := p.Verify(h, nil); err != nil {
+		t.Fatalf("valid key rejected: %v", err)
+	}
+
+	h.Set("Authorization", "ApiKey wrong")
+	if _, err := p.Verify(h, nil); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+
+	if _, err := (&Polka{}).Verify(http.Header{"Authorization": {"ApiKey "}}, nil); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("empty key accepted when none is configured")
+	}
+}
+
+func TestPolkaSigned(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded"}`)
+	p := &Polka{Verifier: webhook.NewVerifier([]string{"secret"}, time.Minute)}
+
+	h := http.Header{}
+	webhook.Sign(h, "secret", "evt_1", time.Now(), body)
+
+	id, err := p.Verify(h, body)
+	if err != nil || id != "evt_1" {
+		t.Fatalf("unexpected verify result %q %v", id, err)
+	}
+
+	// An API key is no use once signing is set up.
+	h = http.Header{}
+	h.Set("Authorization", "ApiKey secret")
+	if _, err := p.Verify(h, body); err == nil {
+		t.Fatalf("unsigned request accepted")
+	}
+}
+
+func TestPolkaParse(t *testing.T) {
+	p := &Polka{}
+
+	n, err := p.Parse([]byte(`{"event":"subscription.canceled","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if n.Event.Type != EventCanceled || n.UserID.String() != "3311741c-680c-4546-99f3-fc9efac2036c" {
+		t.Fatalf("unexpected notification %+v", n)
+	}
+
+	n, err = p.Parse([]byte(`{"event":"user.payment_method_updated","data":{}}`))
+	if err != nil || !n.Ignored() {
+		t.Fatalf("expected ignored notification, got %+v %v", n, err)
+	}
+
+	_, err = p.Parse([]byte(`{"event":"user.upgraded","data":{"user_id":"nope"}}`))
+	if !errors.Is(err, ErrInvalidNotification) {
+		t.Fatalf("expected ErrInvalidNotification, got %v", err)
+	}
+}
+
+func TestUnsignedEventID(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 10, 0, 0, time.UTC)
+	userID := uuid.New()
+	n := Notification{Type: "user.upgraded", UserID: userID}
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"` + userID.String() + `"}}`)
+
+	id := UnsignedEventID(n, body, now)
+
+	if UnsignedEventID(n, body, now.Add(30*time.Minute)) != id {
+		t.Fatalf("resend within the window got a different id")
+	}
+
+	if UnsignedEventID(n, body, now.Add(UnsignedEventWindow)) == id {
+		t.Fatalf("delivery in a later window got the same id")
+	}
+
+	other := Notification{Type: "user.upgraded", UserID: uuid.New()}
+	if UnsignedEventID(other, body, now) == id {
+		t.Fatalf("different user got the same id")
+	}
+
+	if UnsignedEventID(n, []byte(`{}`), now) == id {
+		t.Fatalf("different body got the same id")
+	}
+}
@@ -0,0 +1,66 @@
+package billing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidNotification = errors.New("invalid billing notification")
+	ErrUnauthenticated     = errors.New("billing notification not authenticated")
+)
+
+// Provider reads one payment provider's webhooks. Each provider has its
+// own way of signing requests and naming events, and Provider hides both
+// so every provider feeds the same subscription state machine.
+type Provider interface {
+	// Name identifies the provider in URLs and in the event log.
+	Name() string
+	// Verify checks that the request came from the provider, and returns
+	// the provider's ID for the event. An empty ID means the provider did
+	// not supply one.
+	Verify(header http.Header, body []byte) (string, error)
+	// Parse turns the body into a Notification.
+	Parse(body []byte) (Notification, error)
+}
+
+// Notification is a provider event in provider-neutral terms.
+type Notification struct {
+	// Type is the provider's own name for the event.
+	Type string
+	// Event is the change to the subscription. Its Type is empty for
+	// notifications we do not act on.
+	Event Event
+	// UserID is set when the provider knows which of our users the event
+	// is about. Otherwise CustomerID, the provider's own ID for the
+	// customer, has to be mapped to a user.
+	UserID     uuid.UUID
+	CustomerID string
+}
+
+func (n Notification) Ignored() bool {
+	return n.Event.Type == ""
+}
+
+// UnsignedEventWindow is how long a delivery without a provider event ID is
+// treated as a retry of an earlier identical one.
+const UnsignedEventWindow = time.Hour
+
+// UnsignedEventID keys a delivery that came without a provider event ID.
+// Deliveries of the same event for the same user with the same body, in the
+// same UnsignedEventWindow, share a key and so are handled only once.
+func UnsignedEventID(n Notification, body []byte, now time.Time) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("unsigned:%s:%s:%s:%d",
+		n.Type,
+		n.UserID,
+		hex.EncodeToString(sum[:]),
+		now.Truncate(UnsignedEventWindow).Unix(),
+	)
+}
@@ -0,0 +1,213 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Stripe reads webhooks in Stripe's format. The signature header is
+// "t=<unix time>,v1=<hex>", where the HMAC-SHA256 covers "<t>.<body>".
+// Several v1 entries may be present while a secret is rolled.
+type Stripe struct {
+	Secrets   []string
+	Tolerance time.Duration
+	now       func() time.Time
+}
+
+// NewStripe accepts signatures made with any of secrets. Entries are
+// trimmed and blank ones dropped, so a stray comma cannot add an empty key.
+func NewStripe(secrets []string, tolerance time.Duration) *Stripe {
+	s := &Stripe{Tolerance: tolerance}
+
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if secret != "" {
+			s.Secrets = append(s.Secrets, secret)
+		}
+	}
+
+	return s
+}
+
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+func (s *Stripe) Verify(header http.Header, body []byte) (string, error) {
+	var timestamp string
+	var signatures [][]byte
+
+	for _, part := range strings.Split(header.Get(StripeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return "", fmt.Errorf("%w: malformed signature header", ErrUnauthenticated)
+	}
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+
+	skew := now().Sub(time.Unix(unix, 0))
+	if skew > s.Tolerance || skew < -s.Tolerance {
+		return "", fmt.Errorf("%w: timestamp outside tolerance", ErrUnauthenticated)
+	}
+
+	if len(s.Secrets) == 0 {
+		return "", fmt.Errorf("%w: no signing secrets configured", ErrUnauthenticated)
+	}
+
+	if !s.matches(timestamp, body, signatures) {
+		return "", fmt.Errorf("%w: signature mismatch", ErrUnauthenticated)
+	}
+
+	// The event ID is in the body, which the signature covers.
+	var event struct {
+		ID string `json:"id"`
+	}
+	err = json.Unmarshal(body, &event)
+	if err != nil || event.ID == "" {
+		return "", fmt.Errorf("%w: missing event id", ErrInvalidNotification)
+	}
+
+	return event.ID, nil
+}
+
+func (s *Stripe) matches(timestamp string, body []byte, signatures [][]byte) bool {
+	for _, secret := range s.Secrets {
+		if secret == "" {
+			continue
+		}
+
+		m := hmac.New(sha256.New, []byte(secret))
+		m.Write([]byte(timestamp))
+		m.Write([]byte{'.'})
+		m.Write(body)
+		expected := m.Sum(nil)
+
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type stripeObject struct {
+	Customer          string            `json:"customer"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Status            string            `json:"status"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+func (s *Stripe) Parse(body []byte) (Notification, error) {
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object stripeObject `json:"object"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return Notification{}, fmt.Errorf("%w: %w", ErrInvalidNotification, err)
+	}
+
+	obj := event.Data.Object
+	n := Notification{
+		Type:       event.Type,
+		CustomerID: obj.Customer,
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		// Checkout is where we pass our user ID in, and so where a Stripe
+		// customer first gets tied to a user.
+		n.Event.Type = EventActivated
+		n.UserID, err = stripeUserID(obj.ClientReferenceID)
+
+	case "customer.subscription.created", "customer.subscription.updated":
+		n.Event = stripeSubscriptionEvent(obj)
+		n.UserID, err = stripeUserID(obj.Metadata["user_id"])
+
+	case "customer.subscription.deleted":
+		n.Event.Type = EventDowngraded
+		n.UserID, err = stripeUserID(obj.Metadata["user_id"])
+
+	case "invoice.payment_failed":
+		n.Event.Type = EventPaymentFailed
+
+	default:
+		return n, nil
+	}
+	if err != nil {
+		return Notification{}, err
+	}
+
+	if n.UserID == uuid.Nil && n.CustomerID == "" {
+		return Notification{}, fmt.Errorf("%w: no customer", ErrInvalidNotification)
+	}
+
+	return n, nil
+}
+
+func stripeSubscriptionEvent(obj stripeObject) Event {
+	switch obj.Status {
+	case "active", "trialing":
+		if obj.CancelAtPeriodEnd {
+			return Event{Type: EventCanceled}
+		}
+		e := Event{Type: EventActivated}
+		if obj.CurrentPeriodEnd != 0 {
+			e.PeriodEnd = time.Unix(obj.CurrentPeriodEnd, 0)
+		}
+		return e
+	case "past_due", "unpaid":
+		return Event{Type: EventPaymentFailed}
+	case "canceled", "incomplete_expired":
+		return Event{Type: EventDowngraded}
+	}
+
+	// incomplete: the first payment has not gone through yet.
+	return Event{}
+}
+
+func stripeUserID(raw string) (uuid.UUID, error) {
+	if raw == "" {
+		return uuid.Nil, nil
+	}
+
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: invalid user id: %w", ErrInvalidNotification, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,131 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func stripeSign(secret string, t time.Time, body []byte) string {
+	timestamp := fmt.Sprint(t.Unix())
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp + "." + string(body)))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func TestStripeVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_123","type":"invoice.payment_failed"}`)
+
+	s := &Stripe{
+		Secrets:   []string{"whsec_old", "whsec_new"},
+		Tolerance: 5 * time.Minute,
+		now:       func() time.Time { return now },
+	}
+
+	h := http.Header{}
+	h.Set(StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), stripeSign("whsec_other", now, body), stripeSign("whsec_new", now, body)))
+
+	id, err := s.Verify(h, body)
+	if err != nil || id != "evt_123" {
+		t.Fatalf("unexpected verify result %q %v", id, err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+	}{
+		{"missing", "", body},
+		{"wrong secret", fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("whsec_other", now, body)), body},
+		{"altered body", fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("whsec_new", now, body)), []byte(`{"id":"evt_124"}`)},
+		{"stale", fmt.Sprintf("t=%d,v1=%s", now.Add(-time.Hour).Unix(), stripeSign("whsec_new", now.Add(-time.Hour), body)), body},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set(StripeSignatureHeader, tt.header)
+
+		if _, err := s.Verify(h, tt.body); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("%s: expected ErrUnauthenticated, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestNewStripeSecrets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_123"}`)
+
+	s := NewStripe([]string{" whsec_new ", "", "  "}, 5*time.Minute)
+	s.now = func() time.Time { return now }
+
+	if len(s.Secrets) != 1 || s.Secrets[0] != "whsec_new" {
+		t.Fatalf("unexpected secrets %q", s.Secrets)
+	}
+
+	h := http.Header{}
+	h.Set(StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("whsec_new", now, body)))
+
+	if _, err := s.Verify(h, body); err != nil {
+		t.Fatalf("unexpected verify error %v", err)
+	}
+
+	// With no usable secret, an HMAC keyed with "" must not be accepted.
+	s = NewStripe([]string{"", " "}, 5*time.Minute)
+	s.now = func() time.Time { return now }
+
+	h.Set(StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("", now, body)))
+
+	if _, err := s.Verify(h, body); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+func TestStripeParse(t *testing.T) {
+	s := &Stripe{}
+
+	tests := []struct {
+		body     string
+		want     EventType
+		customer string
+		user     string
+	}{
+		{`{"type":"checkout.session.completed","data":{"object":{"customer":"cus_1","client_reference_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}}`, EventActivated, "cus_1", "3311741c-680c-4546-99f3-fc9efac2036c"},
+		{`{"type":"customer.subscription.updated","data":{"object":{"customer":"cus_1","status":"active","current_period_end":1700000000}}}`, EventActivated, "cus_1", ""},
+		{`{"type":"customer.subscription.updated","data":{"object":{"customer":"cus_1","status":"active","cancel_at_period_end":true}}}`, EventCanceled, "cus_1", ""},
+		{`{"type":"customer.subscription.updated","data":{"object":{"customer":"cus_1","status":"past_due"}}}`, EventPaymentFailed, "cus_1", ""},
+		{`{"type":"customer.subscription.deleted","data":{"object":{"customer":"cus_1","metadata":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}}}`, EventDowngraded, "cus_1", "3311741c-680c-4546-99f3-fc9efac2036c"},
+		{`{"type":"invoice.payment_failed","data":{"object":{"customer":"cus_1"}}}`, EventPaymentFailed, "cus_1", ""},
+		{`{"type":"customer.created","data":{"object":{"customer":"cus_1"}}}`, "", "cus_1", ""},
+	}
+
+	for _, tt := range tests {
+		n, err := s.Parse([]byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.body, err)
+		}
+
+		if n.Event.Type != tt.want || n.CustomerID != tt.customer {
+			t.Fatalf("%s: unexpected notification %+v", tt.body, n)
+		}
+
+		if tt.user != "" && n.UserID.String() != tt.user {
+			t.Fatalf("%s: unexpected user %s", tt.body, n.UserID)
+		}
+	}
+
+	n, _ := s.Parse([]byte(tests[1].body))
+	if !n.Event.PeriodEnd.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected period end %s", n.Event.PeriodEnd)
+	}
+
+	_, err := s.Parse([]byte(`{"type":"invoice.payment_failed","data":{"object":{}}}`))
+	if !errors.Is(err, ErrInvalidNotification) {
+		t.Fatalf("expected ErrInvalidNotification without a customer, got %v", err)
+	}
+}
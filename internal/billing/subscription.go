@@ -0,0 +1,181 @@
+// Package billing models the lifecycle of a Chirpy Red subscription, and
+// reads the webhooks payment providers send about it. It only works out
+// state: callers load a Subscription, Apply an event to it and store the
+// result.
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const PlanChirpyRed = "chirpy_red"
+
+type Status string
+
+const (
+	// StatusNone is the status of a user who has never subscribed.
+	StatusNone     Status = ""
+	StatusActive   Status = "active"
+	StatusPastDue  Status = "past_due"
+	StatusCanceled Status = "canceled"
+	StatusExpired  Status = "expired"
+)
+
+type EventType string
+
+const (
+	// EventActivated starts or renews a subscription.
+	EventActivated     EventType = "activated"
+	EventDowngraded    EventType = "downgraded"
+	EventCanceled      EventType = "canceled"
+	EventPaymentFailed EventType = "payment_failed"
+)
+
+type Event struct {
+	Type EventType
+	Plan string
+	// PeriodEnd is when the paid period ends. Zero means one Policy.Period
+	// from now.
+	PeriodEnd time.Time
+}
+
+var ErrInvalidTransition = errors.New("invalid subscription transition")
+
+type Subscription struct {
+	Plan             string
+	Status           Status
+	CurrentPeriodEnd time.Time
+	// GraceUntil is set when a payment fails, and is how long the user
+	// keeps their benefits while the provider retries.
+	GraceUntil time.Time
+	CanceledAt time.Time
+	EndedAt    time.Time
+	// ExpiresAt is when the subscription lapses unless another event
+	// arrives first.
+	ExpiresAt time.Time
+}
+
+// Policy holds the timings used when applying events.
+type Policy struct {
+	// Period is used as the period length when an event does not say when
+	// the period ends.
+	Period time.Duration
+	// GracePeriod is how long benefits last past a failed payment, or past
+	// the end of a period whose renewal has not arrived.
+	GracePeriod time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Period:      30 * 24 * time.Hour,
+	GracePeriod: 3 * 24 * time.Hour,
+}
+
+// Apply returns s after e happened at now. Events that make no sense for
+// the current status, such as cancelling an expired subscription, return
+// ErrInvalidTransition.
+func (p Policy) Apply(s Subscription, e Event, now time.Time) (Subscription, error) {
+	s = p.Expire(s, now)
+
+	switch e.Type {
+	case EventActivated:
+		periodEnd := e.PeriodEnd
+		if periodEnd.IsZero() {
+			periodEnd = now.Add(p.Period)
+		}
+
+		plan := e.Plan
+		if plan == "" {
+			plan = PlanChirpyRed
+		}
+
+		s = Subscription{
+			Plan:             plan,
+			Status:           StatusActive,
+			CurrentPeriodEnd: periodEnd,
+		}
+
+	case EventDowngraded:
+		if !s.Status.live() {
+			return s, p.invalid(s, e)
+		}
+
+		s.Status = StatusExpired
+		s.EndedAt = now
+
+	case EventCanceled:
+		if s.Status != StatusActive && s.Status != StatusPastDue {
+			return s, p.invalid(s, e)
+		}
+
+		s.Status = StatusCanceled
+		s.CanceledAt = now
+
+	case EventPaymentFailed:
+		switch s.Status {
+		case StatusActive:
+			s.Status = StatusPastDue
+			s.GraceUntil = now.Add(p.GracePeriod)
+		case StatusPastDue:
+			// Providers retry the charge and report each failure; the grace
+			// period runs from the first.
+		default:
+			return s, p.invalid(s, e)
+		}
+
+	default:
+		return s, fmt.Errorf("unknown subscription event %q", e.Type)
+	}
+
+	s.ExpiresAt = p.expiresAt(s)
+	return s, nil
+}
+
+// Expire returns s marked as expired if it has lapsed by now.
+func (p Policy) Expire(s Subscription, now time.Time) Subscription {
+	if s.Status.live() && !now.Before(s.ExpiresAt) {
+		s.Status = StatusExpired
+		s.EndedAt = s.ExpiresAt
+		s.ExpiresAt = time.Time{}
+	}
+
+	return s
+}
+
+// Entitled reports whether s grants its plan's benefits at now.
+func (p Policy) Entitled(s Subscription, now time.Time) bool {
+	return s.Status.live() && now.Before(s.ExpiresAt)
+}
+
+func (p Policy) expiresAt(s Subscription) time.Time {
+	switch s.Status {
+	case StatusActive:
+		return s.CurrentPeriodEnd.Add(p.GracePeriod)
+	case StatusPastDue:
+		return s.GraceUntil
+	case StatusCanceled:
+		// A cancelled subscription runs to the end of the period already
+		// paid for, unless that payment never went through.
+		if !s.GraceUntil.IsZero() && s.GraceUntil.Before(s.CurrentPeriodEnd) {
+			return s.GraceUntil
+		}
+		return s.CurrentPeriodEnd
+	}
+
+	return time.Time{}
+}
+
+func (p Policy) invalid(s Subscription, e Event) error {
+	status := s.Status
+	if status == StatusNone {
+		status = "none"
+	}
+
+	return fmt.Errorf("%w: %s while %s", ErrInvalidTransition, e.Type, status)
+}
+
+// live reports whether the status is one that can still grant benefits.
+func (s Status) live() bool {
+	return s == StatusActive || s == StatusPastDue || s == StatusCanceled
+}
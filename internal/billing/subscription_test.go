@@ -0,0 +1,152 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Period:      30 * 24 * time.Hour,
+	GracePeriod: 3 * 24 * time.Hour,
+}
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func apply(t *testing.T, s Subscription, typ EventType, now time.Time) Subscription {
+	s, err := testPolicy.Apply(s, Event{Type: typ}, now)
+	if err != nil {
+		t.Fatalf("%s: %v", typ, err)
+	}
+	return s
+}
+
+func TestActivate(t *testing.T) {
+	s := apply(t, Subscription{}, EventActivated, start)
+
+	if s.Status != StatusActive || s.Plan != PlanChirpyRed {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+
+	if !s.CurrentPeriodEnd.Equal(start.Add(testPolicy.Period)) {
+		t.Fatalf("unexpected period end %s", s.CurrentPeriodEnd)
+	}
+
+	// A renewal that is late but inside the grace period keeps the user's
+	// benefits throughout.
+	if !testPolicy.Entitled(s, s.CurrentPeriodEnd.Add(testPolicy.GracePeriod-time.Second)) {
+		t.Fatalf("not entitled during grace period")
+	}
+
+	if testPolicy.Entitled(s, s.ExpiresAt) {
+		t.Fatalf("entitled after grace period")
+	}
+
+	periodEnd := start.Add(365 * 24 * time.Hour)
+	s, err := testPolicy.Apply(s, Event{Type: EventActivated, Plan: "chirpy_red_yearly", PeriodEnd: periodEnd}, start)
+	if err != nil {
+		t.Fatalf("renewal: %v", err)
+	}
+
+	if s.Plan != "chirpy_red_yearly" || !s.CurrentPeriodEnd.Equal(periodEnd) {
+		t.Fatalf("unexpected renewal %+v", s)
+	}
+}
+
+func TestCancelRunsToPeriodEnd(t *testing.T) {
+	s := apply(t, Subscription{}, EventActivated, start)
+	s = apply(t, s, EventCanceled, start.Add(time.Hour))
+
+	if s.Status != StatusCanceled || !s.CanceledAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+
+	if !testPolicy.Entitled(s, s.CurrentPeriodEnd.Add(-time.Second)) {
+		t.Fatalf("not entitled before period end")
+	}
+
+	if testPolicy.Entitled(s, s.CurrentPeriodEnd) {
+		t.Fatalf("cancelled subscription entitled past period end")
+	}
+
+	expired := testPolicy.Expire(s, s.CurrentPeriodEnd)
+	if expired.Status != StatusExpired || !expired.EndedAt.Equal(s.CurrentPeriodEnd) {
+		t.Fatalf("unexpected expired subscription %+v", expired)
+	}
+
+	// Resubscribing starts afresh.
+	s = apply(t, expired, EventActivated, s.CurrentPeriodEnd.Add(time.Hour))
+	if s.Status != StatusActive || !s.CanceledAt.IsZero() || !s.EndedAt.IsZero() {
+		t.Fatalf("unexpected resubscription %+v", s)
+	}
+}
+
+func TestPaymentFailed(t *testing.T) {
+	s := apply(t, Subscription{}, EventActivated, start)
+
+	failedAt := s.CurrentPeriodEnd
+	s = apply(t, s, EventPaymentFailed, failedAt)
+	if s.Status != StatusPastDue || !s.ExpiresAt.Equal(failedAt.Add(testPolicy.GracePeriod)) {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+
+	// Later failures do not push the grace period out.
+	s = apply(t, s, EventPaymentFailed, failedAt.Add(24*time.Hour))
+	if !s.ExpiresAt.Equal(failedAt.Add(testPolicy.GracePeriod)) {
+		t.Fatalf("grace period extended to %s", s.ExpiresAt)
+	}
+
+	// A successful retry makes it active again.
+	recovered := apply(t, s, EventActivated, failedAt.Add(48*time.Hour))
+	if recovered.Status != StatusActive || !recovered.GraceUntil.IsZero() {
+		t.Fatalf("unexpected recovered subscription %+v", recovered)
+	}
+
+	// Retries that all fail let it lapse.
+	if testPolicy.Entitled(s, failedAt.Add(testPolicy.GracePeriod)) {
+		t.Fatalf("entitled after grace period")
+	}
+
+	_, err := testPolicy.Apply(s, Event{Type: EventPaymentFailed}, failedAt.Add(testPolicy.GracePeriod))
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("expected ErrInvalidTransition on lapsed subscription, got %v", err)
+	}
+}
+
+func TestDowngradeIsImmediate(t *testing.T) {
+	s := apply(t, Subscription{}, EventActivated, start)
+	s = apply(t, s, EventDowngraded, start.Add(time.Hour))
+
+	if s.Status != StatusExpired || !s.EndedAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+
+	if testPolicy.Entitled(s, start.Add(time.Hour)) {
+		t.Fatalf("downgraded subscription still entitled")
+	}
+}
+
+func TestInvalidTransitions(t *testing.T) {
+	canceled := apply(t, apply(t, Subscription{}, EventActivated, start), EventCanceled, start)
+	expired := apply(t, apply(t, Subscription{}, EventActivated, start), EventDowngraded, start)
+
+	tests := []struct {
+		name string
+		s    Subscription
+		typ  EventType
+	}{
+		{"downgrade without subscription", Subscription{}, EventDowngraded},
+		{"cancel without subscription", Subscription{}, EventCanceled},
+		{"payment failed without subscription", Subscription{}, EventPaymentFailed},
+		{"cancel twice", canceled, EventCanceled},
+		{"payment failed after cancel", canceled, EventPaymentFailed},
+		{"downgrade after expiry", expired, EventDowngraded},
+	}
+
+	for _, tt := range tests {
+		_, err := testPolicy.Apply(tt.s, Event{Type: tt.typ}, start)
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("%s: expected ErrInvalidTransition, got %v", tt.name, err)
+		}
+	}
+}
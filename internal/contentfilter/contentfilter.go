@@ -0,0 +1,314 @@
+// Package contentfilter matches chirps against a set of word and phrase
+// rules. Matching folds case across Unicode, can be limited to whole words,
+// and can see through common leetspeak substitutions. Each rule says
+// whether a match is masked, rejected or flagged for review.
+package contentfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+type Action string
+
+const (
+	Mask   Action = "mask"
+	Reject Action = "reject"
+	Flag   Action = "flag"
+)
+
+// Masked replaces the text of a match with a Mask action.
+const Masked = "****"
+
+var (
+	ErrEmptyPattern  = errors.New("rule pattern is empty")
+	ErrUnknownAction = errors.New("unknown rule action")
+)
+
+type Rule struct {
+	// ID identifies where the rule came from, such as its database row.
+	// It is reported back in matches and not otherwise used.
+	ID      string `json:"id,omitempty"`
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+	// WholeWord stops the pattern matching inside a longer word.
+	WholeWord bool `json:"whole_word"`
+	// Leet lets digits and symbols stand in for the letters they look
+	// like, so "f0rn4x" matches "fornax".
+	Leet bool `json:"leet"`
+}
+
+func (r Rule) Validate() error {
+	if strings.TrimSpace(r.Pattern) == "" {
+		return ErrEmptyPattern
+	}
+
+	switch r.Action {
+	case Mask, Reject, Flag:
+		return nil
+	}
+
+	return fmt.Errorf("%w %q", ErrUnknownAction, r.Action)
+}
+
+// DefaultRules are used when no other rules are configured.
+var DefaultRules = []Rule{
+	{ID: "default:kerfuffle", Pattern: "kerfuffle", Action: Mask, WholeWord: true, Leet: true},
+	{ID: "default:sharbert", Pattern: "sharbert", Action: Mask, WholeWord: true, Leet: true},
+	{ID: "default:fornax", Pattern: "fornax", Action: Mask, WholeWord: true, Leet: true},
+}
+
+// ParseRules reads a JSON array of rules, as kept in a config file.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+
+	err := json.NewDecoder(r).Decode(&rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		err := rule.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// leet lists what may stand in for each letter when a rule allows
+// leetspeak.
+var leet = map[rune]string{
+	'a': "4@",
+	'b': "8",
+	'e': "3",
+	'g': "69",
+	'i': "1!|",
+	'l': "1|",
+	'o': "0",
+	's': "5$",
+	't': "7+",
+	'z': "2",
+}
+
+// fold maps r to a single representative of its Unicode case folding
+// orbit, so that every case variant of a letter folds to the same rune.
+func fold(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// pattern is a compiled rule. A nil entry in runes matches one or more
+// spaces.
+type pattern struct {
+	rule  Rule
+	runes [][]rune
+}
+
+func compile(r Rule) (pattern, error) {
+	err := r.Validate()
+	if err != nil {
+		return pattern{}, err
+	}
+
+	p := pattern{rule: r}
+	space := false
+
+	for _, c := range strings.TrimSpace(r.Pattern) {
+		if unicode.IsSpace(c) {
+			if !space {
+				p.runes = append(p.runes, nil)
+			}
+			space = true
+			continue
+		}
+		space = false
+
+		alts := []rune{fold(c)}
+		if r.Leet {
+			for _, l := range leet[unicode.ToLower(c)] {
+				alts = append(alts, l)
+			}
+		}
+		p.runes = append(p.runes, alts)
+	}
+
+	return p, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// matchAt returns the index in text just past a match of p starting at
+// start, or -1.
+func (p pattern) matchAt(text []rune, start int) int {
+	i := start
+
+	for _, alts := range p.runes {
+		if alts == nil {
+			n := i
+			for n < len(text) && unicode.IsSpace(text[n]) {
+				n++
+			}
+			if n == i {
+				return -1
+			}
+			i = n
+			continue
+		}
+
+		if i >= len(text) {
+			return -1
+		}
+
+		found := false
+		for _, a := range alts {
+			if fold(text[i]) == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return -1
+		}
+		i++
+	}
+
+	if p.rule.WholeWord {
+		if start > 0 && isWordRune(text[start-1]) {
+			return -1
+		}
+		if i < len(text) && isWordRune(text[i]) {
+			return -1
+		}
+	}
+
+	return i
+}
+
+type Match struct {
+	Rule Rule
+	// Text is the matched text as it appeared in the input.
+	Text string
+}
+
+type Result struct {
+	// Body is the input with every masked match replaced by Masked.
+	Body    string
+	Matches []Match
+}
+
+func (r Result) has(a Action) bool {
+	for _, m := range r.Matches {
+		if m.Rule.Action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejected reports whether any match was for a Reject rule.
+func (r Result) Rejected() bool {
+	return r.has(Reject)
+}
+
+// Flagged reports whether any match was for a Flag rule.
+func (r Result) Flagged() bool {
+	return r.has(Flag)
+}
+
+type ruleset struct {
+	rules    []Rule
+	patterns []pattern
+}
+
+// Filter applies a set of rules that can be replaced at any time, even
+// while other goroutines are filtering.
+type Filter struct {
+	set atomic.Pointer[ruleset]
+}
+
+func New(rules []Rule) (*Filter, error) {
+	f := &Filter{}
+
+	err := f.Load(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Load replaces the filter's rules. If any rule is invalid the old rules
+// are kept.
+func (f *Filter) Load(rules []Rule) error {
+	set := &ruleset{rules: append([]Rule(nil), rules...)}
+
+	for _, r := range rules {
+		p, err := compile(r)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Pattern, err)
+		}
+		set.patterns = append(set.patterns, p)
+	}
+
+	f.set.Store(set)
+	return nil
+}
+
+// Rules returns the rules currently in effect.
+func (f *Filter) Rules() []Rule {
+	return append([]Rule(nil), f.set.Load().rules...)
+}
+
+// Apply runs every rule over body. Where matches overlap, the one starting
+// first wins, and then the longest.
+func (f *Filter) Apply(body string) Result {
+	set := f.set.Load()
+	text := []rune(body)
+
+	var out strings.Builder
+	result := Result{}
+
+	for i := 0; i < len(text); {
+		var best *pattern
+		end := -1
+
+		for n := range set.patterns {
+			if e := set.patterns[n].matchAt(text, i); e > end {
+				best, end = &set.patterns[n], e
+			}
+		}
+
+		if best == nil {
+			out.WriteRune(text[i])
+			i++
+			continue
+		}
+
+		matched := string(text[i:end])
+		result.Matches = append(result.Matches, Match{Rule: best.rule, Text: matched})
+
+		if best.rule.Action == Mask {
+			out.WriteString(Masked)
+		} else {
+			out.WriteString(matched)
+		}
+		i = end
+	}
+
+	result.Body = out.String()
+	return result
+}
@@ -0,0 +1,129 @@
+package contentfilter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustNew(t *testing.T, rules ...Rule) *Filter {
+	t.Helper()
+
+	f, err := New(rules)
+	if err != nil {
+		t.Fatalf("failed to build filter: %v", err)
+	}
+	return f
+}
+
+func TestApplyMask(t *testing.T) {
+	f := mustNew(t, DefaultRules...)
+
+	tests := []struct {
+		in, want string
+	}{
+		{"I had something interesting for breakfast", "I had something interesting for breakfast"},
+		{"I hear Mastodon is better than Chirpy. sharbert I need to migrate", "I hear Mastodon is better than Chirpy. **** I need to migrate"},
+		{"I really need a kerfuffle to go to bed sooner, Fornax !", "I really need a **** to go to bed sooner, **** !"},
+		{"KERFUFFLE, Sharbert.", "****, ****."},
+		{"k3rfuffl3 and f0rn4x", "**** and ****"},
+		{"kerfuffles are whole words", "kerfuffles are whole words"},
+	}
+
+	for _, tt := range tests {
+		if got := f.Apply(tt.in).Body; got != tt.want {
+			t.Fatalf("Apply(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyCaseFolding(t *testing.T) {
+	f := mustNew(t, Rule{Pattern: "straße", Action: Mask}, Rule{Pattern: "σοφία", Action: Mask})
+
+	if got := f.Apply("STRAẞE ΣΟΦΊΑ").Body; got != "**** ****" {
+		t.Fatalf("unexpected body %q", got)
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	f := mustNew(t, Rule{Pattern: "fornax", Action: Mask})
+
+	if got := f.Apply("fornaxes").Body; got != "****es" {
+		t.Fatalf("expected a substring match, got %q", got)
+	}
+
+	if got := f.Apply("f0rnax").Body; got != "f0rnax" {
+		t.Fatalf("expected no leetspeak match, got %q", got)
+	}
+}
+
+func TestApplyPhrase(t *testing.T) {
+	f := mustNew(t, Rule{Pattern: "bad  word", Action: Mask, WholeWord: true})
+
+	if got := f.Apply("a bad \t word here").Body; got != "a **** here" {
+		t.Fatalf("unexpected body %q", got)
+	}
+
+	if got := f.Apply("badword").Body; got != "badword" {
+		t.Fatalf("phrase matched without a space: %q", got)
+	}
+}
+
+func TestApplyActions(t *testing.T) {
+	f := mustNew(t,
+		Rule{ID: "r", Pattern: "spam", Action: Reject, WholeWord: true},
+		Rule{ID: "f", Pattern: "scam", Action: Flag, WholeWord: true},
+	)
+
+	res := f.Apply("not a scam")
+	if res.Rejected() || !res.Flagged() || res.Body != "not a scam" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	res = f.Apply("Spam and SCAM")
+	if !res.Rejected() || !res.Flagged() || len(res.Matches) != 2 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if res.Matches[0].Rule.ID != "r" || res.Matches[0].Text != "Spam" {
+		t.Fatalf("unexpected match %+v", res.Matches[0])
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f := mustNew(t, Rule{Pattern: "old", Action: Mask})
+
+	err := f.Load([]Rule{{Pattern: "new", Action: "shout"}})
+	if !errors.Is(err, ErrUnknownAction) {
+		t.Fatalf("expected ErrUnknownAction, got %v", err)
+	}
+
+	if got := f.Apply("old new").Body; got != "**** new" {
+		t.Fatalf("failed load replaced rules: %q", got)
+	}
+
+	err = f.Load([]Rule{{Pattern: "new", Action: Mask}})
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	if got := f.Apply("old new").Body; got != "old ****" {
+		t.Fatalf("rules not replaced: %q", got)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`[{"pattern": "fornax", "action": "reject", "whole_word": true}]`))
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+
+	if len(rules) != 1 || rules[0].Action != Reject || !rules[0].WholeWord || rules[0].Leet {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	_, err = ParseRules(strings.NewReader(`[{"pattern": " ", "action": "mask"}]`))
+	if !errors.Is(err, ErrEmptyPattern) {
+		t.Fatalf("expected ErrEmptyPattern, got %v", err)
+	}
+}
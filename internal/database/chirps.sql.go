@@ -7,10 +7,84 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const claimUnannouncedChirps = `-- name: ClaimUnannouncedChirps :many
+UPDATE chirps
+SET announced_at = NOW()
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE announced_at IS NULL
+    AND published_at <= NOW()
+    ORDER BY published_at ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at
+`
+
+func (q *Queries) ClaimUnannouncedChirps(ctx context.Context, limit int32) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, claimUnannouncedChirps, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishedAt,
+			&i.HiddenAt,
+			&i.HiddenReason,
+			&i.AnnouncedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countChirpsByUser = `-- name: CountChirpsByUser :one
+SELECT COUNT(*) FROM chirps
+    WHERE user_id = $1
+`
+
+func (q *Queries) CountChirpsByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countScheduledChirpsByUser = `-- name: CountScheduledChirpsByUser :one
+SELECT COUNT(*) FROM chirps
+    WHERE user_id = $1
+    AND published_at > NOW()
+`
+
+func (q *Queries) CountScheduledChirpsByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countScheduledChirpsByUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const deleteChirp = `-- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE id = $1
@@ -22,7 +96,7 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at FROM chirps
     WHERE id = $1
 `
 
@@ -35,17 +109,103 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishedAt,
+		&i.HiddenAt,
+		&i.HiddenReason,
+		&i.AnnouncedAt,
 	)
 	return i, err
 }
 
+const getChirpRateWindow = `-- name: GetChirpRateWindow :one
+SELECT COUNT(*) AS sent, COALESCE(MIN(created_at), NOW())::timestamp AS oldest FROM chirps
+    WHERE user_id = $1
+    AND created_at > $2
+`
+
+type GetChirpRateWindowParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type GetChirpRateWindowRow struct {
+	Sent   int64
+	Oldest time.Time
+}
+
+func (q *Queries) GetChirpRateWindow(ctx context.Context, arg GetChirpRateWindowParams) (GetChirpRateWindowRow, error) {
+	row := q.db.QueryRowContext(ctx, getChirpRateWindow, arg.UserID, arg.CreatedAt)
+	var i GetChirpRateWindowRow
+	err := row.Scan(&i.Sent, &i.Oldest)
+	return i, err
+}
+
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-    ORDER BY created_at ASC
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.published_at, chirps.hidden_at, chirps.hidden_reason, chirps.announced_at FROM chirps
+    JOIN users ON users.id = chirps.user_id
+    WHERE chirps.published_at <= NOW()
+    AND (
+        chirps.user_id = $1
+        OR (chirps.hidden_at IS NULL AND users.shadow_banned_at IS NULL)
+    )
+    ORDER BY chirps.published_at ASC
+`
+
+func (q *Queries) GetChirps(ctx context.Context, viewerID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishedAt,
+			&i.HiddenAt,
+			&i.HiddenReason,
+			&i.AnnouncedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByUserPage = `-- name: GetChirpsByUserPage :many
+SELECT id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at FROM chirps
+    WHERE user_id = $1
+    AND (created_at, id) > ($2::timestamp, $3::uuid)
+    ORDER BY created_at ASC, id ASC
+    LIMIT $4
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpsByUserPageParams struct {
+	UserID         uuid.UUID
+	AfterCreatedAt time.Time
+	AfterID        uuid.UUID
+	PageSize       int32
+}
+
+func (q *Queries) GetChirpsByUserPage(ctx context.Context, arg GetChirpsByUserPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByUserPage,
+		arg.UserID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +219,10 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishedAt,
+			&i.HiddenAt,
+			&i.HiddenReason,
+			&i.AnnouncedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -73,25 +237,103 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
+const hideChirp = `-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW(),
+    hidden_reason = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at
+`
+
+type HideChirpParams struct {
+	HiddenReason sql.NullString
+	ID           uuid.UUID
+}
+
+func (q *Queries) HideChirp(ctx context.Context, arg HideChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, hideChirp, arg.HiddenReason, arg.ID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishedAt,
+		&i.HiddenAt,
+		&i.HiddenReason,
+		&i.AnnouncedAt,
+	)
+	return i, err
+}
+
+const markChirpAnnounced = `-- name: MarkChirpAnnounced :execrows
+UPDATE chirps
+SET announced_at = NOW()
+WHERE id = $1
+AND announced_at IS NULL
+`
+
+func (q *Queries) MarkChirpAnnounced(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markChirpAnnounced, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const newChirp = `-- name: NewChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, published_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    COALESCE($3::timestamp, NOW())
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at
 `
 
 type NewChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body        string
+	UserID      uuid.UUID
+	PublishedAt sql.NullTime
 }
 
 func (q *Queries) NewChirp(ctx context.Context, arg NewChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, newChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, newChirp, arg.Body, arg.UserID, arg.PublishedAt)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishedAt,
+		&i.HiddenAt,
+		&i.HiddenReason,
+		&i.AnnouncedAt,
+	)
+	return i, err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, body, user_id, published_at, hidden_at, hidden_reason, announced_at
+`
+
+type UpdateChirpBodyParams struct {
+	Body string
+	ID   uuid.UUID
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.Body, arg.ID)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -99,6 +341,10 @@ func (q *Queries) NewChirp(ctx context.Context, arg NewChirpParams) (Chirp, erro
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishedAt,
+		&i.HiddenAt,
+		&i.HiddenReason,
+		&i.AnnouncedAt,
 	)
 	return i, err
 }
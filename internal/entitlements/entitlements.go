@@ -0,0 +1,117 @@
+// Package entitlements decides what each plan lets a user do. Handlers ask
+// it instead of checking is_chirpy_red themselves, so a perk is added or
+// changed here and nowhere else.
+package entitlements
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+type Feature string
+
+const (
+	EditChirps     Feature = "edit_chirps"
+	ScheduleChirps Feature = "schedule_chirps"
+)
+
+// RateWindow is the period ChirpsPerHour is counted over.
+const RateWindow = time.Hour
+
+type Plan struct {
+	Name string
+	// MaxChirpLength is in characters, not bytes.
+	MaxChirpLength int
+	ChirpsPerHour  int
+	// MaxScheduledChirps caps how many chirps may wait to be published,
+	// and MaxScheduleAhead how far ahead they may be scheduled.
+	MaxScheduledChirps int
+	MaxScheduleAhead   time.Duration
+	Features           []Feature
+}
+
+var Free = Plan{
+	Name:           "free",
+	MaxChirpLength: 140,
+	ChirpsPerHour:  30,
+}
+
+var ChirpyRed = Plan{
+	Name:               "chirpy_red",
+	MaxChirpLength:     1000,
+	ChirpsPerHour:      300,
+	MaxScheduledChirps: 100,
+	MaxScheduleAhead:   90 * 24 * time.Hour,
+	Features:           []Feature{EditChirps, ScheduleChirps},
+}
+
+func ForUser(isChirpyRed bool) Plan {
+	if isChirpyRed {
+		return ChirpyRed
+	}
+	return Free
+}
+
+var (
+	ErrNotEntitled      = errors.New("not included in plan")
+	ErrChirpTooLong     = errors.New("chirp is too long")
+	ErrScheduleInPast   = errors.New("scheduled time is in the past")
+	ErrScheduleTooFar   = errors.New("scheduled time is too far ahead")
+	ErrTooManyScheduled = errors.New("too many scheduled chirps")
+)
+
+func (p Plan) Allows(f Feature) bool {
+	return slices.Contains(p.Features, f)
+}
+
+// Require returns ErrNotEntitled unless the plan includes f.
+func (p Plan) Require(f Feature) error {
+	if !p.Allows(f) {
+		return fmt.Errorf("%w: %s", ErrNotEntitled, f)
+	}
+	return nil
+}
+
+func (p Plan) CheckChirpLength(body string) error {
+	if utf8.RuneCountInString(body) > p.MaxChirpLength {
+		return fmt.Errorf("%w: limit is %d characters", ErrChirpTooLong, p.MaxChirpLength)
+	}
+	return nil
+}
+
+// ChirpRateExceeded reports whether sent chirps within the last RateWindow
+// use up the plan's allowance. If so it returns how long until the oldest
+// of them, sent at oldest, leaves the window.
+func (p Plan) ChirpRateExceeded(sent int, oldest, now time.Time) (time.Duration, bool) {
+	if sent < p.ChirpsPerHour {
+		return 0, false
+	}
+
+	return max(oldest.Add(RateWindow).Sub(now), time.Second), true
+}
+
+// CheckSchedule checks that a chirp may be published at publishAt, given
+// pending chirps already waiting.
+func (p Plan) CheckSchedule(publishAt, now time.Time, pending int) error {
+	err := p.Require(ScheduleChirps)
+	if err != nil {
+		return err
+	}
+
+	if !publishAt.After(now) {
+		return ErrScheduleInPast
+	}
+
+	if publishAt.Sub(now) > p.MaxScheduleAhead {
+		return fmt.Errorf("%w: limit is %s", ErrScheduleTooFar, p.MaxScheduleAhead)
+	}
+
+	if pending >= p.MaxScheduledChirps {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyScheduled, p.MaxScheduledChirps)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package entitlements
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChirpLength(t *testing.T) {
+	if err := Free.CheckChirpLength(strings.Repeat("a", 140)); err != nil {
+		t.Fatalf("140 characters rejected: %v", err)
+	}
+
+	if err := Free.CheckChirpLength(strings.Repeat("a", 141)); !errors.Is(err, ErrChirpTooLong) {
+		t.Fatalf("expected ErrChirpTooLong, got %v", err)
+	}
+
+	// Characters, not bytes: each of these is three bytes in UTF-8.
+	if err := Free.CheckChirpLength(strings.Repeat("€", 140)); err != nil {
+		t.Fatalf("140 multi-byte characters rejected: %v", err)
+	}
+
+	if err := ChirpyRed.CheckChirpLength(strings.Repeat("a", 141)); err != nil {
+		t.Fatalf("red chirp rejected: %v", err)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	if ForUser(false).Allows(EditChirps) {
+		t.Fatalf("free plan allows editing")
+	}
+
+	if err := ForUser(false).Require(EditChirps); !errors.Is(err, ErrNotEntitled) {
+		t.Fatalf("expected ErrNotEntitled, got %v", err)
+	}
+
+	if err := ForUser(true).Require(EditChirps); err != nil {
+		t.Fatalf("red plan does not allow editing: %v", err)
+	}
+}
+
+func TestChirpRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	if _, exceeded := Free.ChirpRateExceeded(Free.ChirpsPerHour-1, now.Add(-time.Minute), now); exceeded {
+		t.Fatalf("limit exceeded below allowance")
+	}
+
+	retryAfter, exceeded := Free.ChirpRateExceeded(Free.ChirpsPerHour, now.Add(-20*time.Minute), now)
+	if !exceeded || retryAfter != 40*time.Minute {
+		t.Fatalf("unexpected rate result %s %t", retryAfter, exceeded)
+	}
+
+	if _, exceeded := ChirpyRed.ChirpRateExceeded(Free.ChirpsPerHour, now.Add(-20*time.Minute), now); exceeded {
+		t.Fatalf("red plan limited at the free allowance")
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name    string
+		plan    Plan
+		at      time.Time
+		pending int
+		want    error
+	}{
+		{"free", Free, now.Add(time.Hour), 0, ErrNotEntitled},
+		{"past", ChirpyRed, now.Add(-time.Hour), 0, ErrScheduleInPast},
+		{"too far", ChirpyRed, now.Add(ChirpyRed.MaxScheduleAhead + time.Hour), 0, ErrScheduleTooFar},
+		{"too many", ChirpyRed, now.Add(time.Hour), ChirpyRed.MaxScheduledChirps, ErrTooManyScheduled},
+	}
+
+	for _, tt := range tests {
+		err := tt.plan.CheckSchedule(tt.at, now, tt.pending)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	if err := ChirpyRed.CheckSchedule(now.Add(time.Hour), now, 0); err != nil {
+		t.Fatalf("valid schedule rejected: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Archive writes a ZIP of JSON documents straight to an io.Writer, so an
+// export never has to be held in memory.
+type Archive struct {
+	zw  *zip.Writer
+	now time.Time
+}
+
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{
+		zw:  zip.NewWriter(w),
+		now: time.Now(),
+	}
+}
+
+func (a *Archive) create(name string) (io.Writer, error) {
+	return a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: a.now,
+	})
+}
+
+// WriteJSON adds a file called name holding v as indented JSON.
+func (a *Archive) WriteJSON(name string, v any) error {
+	f, err := a.create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// WriteJSONArray adds a file called name holding a JSON array. Items are
+// pulled from next one at a time until it reports ok as false.
+func (a *Archive) WriteJSONArray(name string, next func() (item any, ok bool, err error)) error {
+	f, err := a.create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(f, "[")
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		item, ok, err := next()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		sep := ",\n  "
+		if i == 0 {
+			sep = "\n  "
+		}
+
+		_, err = io.WriteString(f, sep)
+		if err != nil {
+			return err
+		}
+
+		dat, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(dat)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(f, "\n]\n")
+	return err
+}
+
+func (a *Archive) Close() error {
+	return a.zw.Close()
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func readFile(t *testing.T, r *zip.Reader, name string) []byte {
+	t.Helper()
+
+	f, err := r.Open(name)
+
+	if err != nil {
+		t.Fatalf("missing %s: %v", name, err)
+	}
+	defer f.Close()
+
+	dat, err := io.ReadAll(f)
+
+	if err != nil {
+		t.Fatalf("failed reading %s: %v", name, err)
+	}
+
+	return dat
+}
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := NewArchive(&buf)
+
+	err := a.WriteJSON("profile.json", map[string]string{"email": "walt@example.com"})
+
+	if err != nil {
+		t.Fatalf("failed writing profile: %v", err)
+	}
+
+	items := []int{1, 2, 3}
+	i := 0
+	err = a.WriteJSONArray("chirps.json", func() (any, bool, error) {
+		if i == len(items) {
+			return nil, false, nil
+		}
+		i++
+		return items[i-1], true, nil
+	})
+
+	if err != nil {
+		t.Fatalf("failed writing chirps: %v", err)
+	}
+
+	err = a.WriteJSONArray("empty.json", func() (any, bool, error) {
+		return nil, false, nil
+	})
+
+	if err != nil {
+		t.Fatalf("failed writing empty array: %v", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("failed closing archive: %v", err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	var profile map[string]string
+	if err := json.Unmarshal(readFile(t, r, "profile.json"), &profile); err != nil || profile["email"] != "walt@example.com" {
+		t.Fatalf("unexpected profile %v: %v", profile, err)
+	}
+
+	var chirps []int
+	if err := json.Unmarshal(readFile(t, r, "chirps.json"), &chirps); err != nil || len(chirps) != 3 {
+		t.Fatalf("unexpected chirps %v: %v", chirps, err)
+	}
+
+	var empty []int
+	if err := json.Unmarshal(readFile(t, r, "empty.json"), &empty); err != nil || len(empty) != 0 {
+		t.Fatalf("unexpected empty array %v: %v", empty, err)
+	}
+}
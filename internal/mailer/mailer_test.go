@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+
+	err := m.Send(context.Background(), Message{To: "walt@example.com", Subject: "hi", Body: "hello"})
+
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	msgs := m.Messages()
+
+	if len(msgs) != 1 || msgs[0].To != "walt@example.com" {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+}
+
+func TestFormatMessageStripsHeaderInjection(t *testing.T) {
+	raw := string(formatMessage("chirpy@example.com", Message{
+		To:      "walt@example.com\r\nBcc: jesse@example.com",
+		Subject: "Reset\nyour password",
+		Body:    "line one\nline two",
+	}, time.Unix(0, 0)))
+
+	if strings.Contains(raw, "\r\nBcc:") {
+		t.Fatalf("header injection not stripped:\n%s", raw)
+	}
+
+	if !strings.Contains(raw, "Subject: Resetyour password\r\n") {
+		t.Fatalf("unexpected subject:\n%s", raw)
+	}
+
+	if !strings.HasSuffix(raw, "\r\n\r\nline one\r\nline two") {
+		t.Fatalf("unexpected body:\n%s", raw)
+	}
+}
+
+func TestSMTPMailerHonorsDeadline(t *testing.T) {
+	// Accepts connections but never sends a greeting.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		<-done
+		conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	m := NewSMTPMailer(host, port, "", "", "chirpy@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = m.Send(ctx, Message{To: "walt@example.com", Subject: "hi", Body: "hello"})
+
+	if err == nil {
+		t.Fatalf("expected an error from a stalled server")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("send ignored its deadline, took %v", elapsed)
+	}
+}
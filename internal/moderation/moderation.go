@@ -0,0 +1,150 @@
+// Package moderation holds the rules for reports and the actions
+// moderators take on them. Storing reports and applying actions is left to
+// the caller.
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Reasons a user may give when reporting a chirp or an account.
+const (
+	ReasonSpam       = "spam"
+	ReasonHarassment = "harassment"
+	ReasonHate       = "hate"
+	ReasonViolence   = "violence"
+	ReasonSexual     = "sexual"
+	ReasonOther      = "other"
+)
+
+var reasons = []string{ReasonSpam, ReasonHarassment, ReasonHate, ReasonViolence, ReasonSexual, ReasonOther}
+
+// ReasonFilter is given on reports raised by the content filter. Users
+// cannot choose it.
+const ReasonFilter = "filter"
+
+// MaxDetailsLength is in characters, not bytes.
+const MaxDetailsLength = 1000
+
+const (
+	ActionDismiss   = "dismiss"
+	ActionHideChirp = "hide_chirp"
+	ActionSuspend   = "suspend"
+	ActionBan       = "ban"
+	ActionShadowBan = "shadow_ban"
+
+	// Lifting a restriction is done directly on the account, never as
+	// the resolution of a report.
+	ActionLiftSuspension = "lift_suspension"
+	ActionLiftShadowBan  = "lift_shadow_ban"
+)
+
+var (
+	ErrUnknownReason   = errors.New("unknown report reason")
+	ErrDetailsTooLong  = errors.New("report details too long")
+	ErrUnknownAction   = errors.New("unknown moderation action")
+	ErrReasonRequired  = errors.New("a reason is required")
+	ErrNotChirpReport  = errors.New("report is not about a chirp")
+	ErrInvalidDuration = errors.New("suspension needs a positive duration")
+
+	ErrSuspended = errors.New("account suspended")
+	ErrBanned    = errors.New("account banned")
+)
+
+// CheckReport validates what a user wrote when filing a report.
+func CheckReport(reason, details string) error {
+	if !slices.Contains(reasons, reason) {
+		return ErrUnknownReason
+	}
+
+	if utf8.RuneCountInString(details) > MaxDetailsLength {
+		return fmt.Errorf("%w: at most %d characters", ErrDetailsTooLong, MaxDetailsLength)
+	}
+
+	return nil
+}
+
+// Decision is a moderator's resolution of a report.
+type Decision struct {
+	Action string
+	// Reason is recorded in the audit log and, for hidden chirps, shown to
+	// the author. Only dismissals may leave it empty.
+	Reason string
+	// Duration is how long a suspension lasts.
+	Duration time.Duration
+}
+
+// Check reports whether d can resolve a report, which is about a chirp if
+// chirpReport is set and about an account otherwise.
+func (d Decision) Check(chirpReport bool) error {
+	switch d.Action {
+	case ActionDismiss:
+		return nil
+	case ActionHideChirp:
+		if !chirpReport {
+			return ErrNotChirpReport
+		}
+	case ActionSuspend:
+		if d.Duration <= 0 {
+			return ErrInvalidDuration
+		}
+	case ActionBan, ActionShadowBan:
+	default:
+		return ErrUnknownAction
+	}
+
+	if strings.TrimSpace(d.Reason) == "" {
+		return ErrReasonRequired
+	}
+
+	return nil
+}
+
+// Account is the moderation state of a user. Zero times mean the user was
+// never suspended or banned.
+type Account struct {
+	SuspendedUntil   time.Time
+	SuspensionReason string
+	BannedAt         time.Time
+	BanReason        string
+	// ShadowBanned accounts work as normal, but nobody else sees their
+	// chirps. It is not checked by Check.
+	ShadowBanned bool
+}
+
+// Restriction is the error Check returns, saying why an account may not be
+// used.
+type Restriction struct {
+	// Err is ErrSuspended or ErrBanned.
+	Err    error
+	Reason string
+	// Until is zero for bans, which do not end.
+	Until time.Time
+}
+
+func (r *Restriction) Error() string {
+	return r.Err.Error()
+}
+
+func (r *Restriction) Unwrap() error {
+	return r.Err
+}
+
+// Check returns a *Restriction if the account may not sign in or use its
+// tokens at now.
+func (a Account) Check(now time.Time) error {
+	if !a.BannedAt.IsZero() {
+		return &Restriction{Err: ErrBanned, Reason: a.BanReason}
+	}
+
+	if a.SuspendedUntil.After(now) {
+		return &Restriction{Err: ErrSuspended, Reason: a.SuspensionReason, Until: a.SuspendedUntil}
+	}
+
+	return nil
+}
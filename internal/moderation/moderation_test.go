@@ -0,0 +1,84 @@
+package moderation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckReport(t *testing.T) {
+	if err := CheckReport(ReasonSpam, ""); err != nil {
+		t.Fatalf("valid report rejected: %v", err)
+	}
+
+	if err := CheckReport("boring", ""); !errors.Is(err, ErrUnknownReason) {
+		t.Fatalf("expected ErrUnknownReason, got %v", err)
+	}
+
+	// Counted in characters, so a multi-byte limit is still allowed.
+	if err := CheckReport(ReasonOther, strings.Repeat("é", MaxDetailsLength)); err != nil {
+		t.Fatalf("details at the limit rejected: %v", err)
+	}
+
+	if err := CheckReport(ReasonOther, strings.Repeat("a", MaxDetailsLength+1)); !errors.Is(err, ErrDetailsTooLong) {
+		t.Fatalf("expected ErrDetailsTooLong, got %v", err)
+	}
+}
+
+func TestDecisionCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		decision    Decision
+		chirpReport bool
+		want        error
+	}{
+		{"dismiss without reason", Decision{Action: ActionDismiss}, true, nil},
+		{"hide chirp", Decision{Action: ActionHideChirp, Reason: "spam"}, true, nil},
+		{"hide account", Decision{Action: ActionHideChirp, Reason: "spam"}, false, ErrNotChirpReport},
+		{"hide without reason", Decision{Action: ActionHideChirp, Reason: "  "}, true, ErrReasonRequired},
+		{"suspend", Decision{Action: ActionSuspend, Reason: "abuse", Duration: time.Hour}, false, nil},
+		{"suspend without duration", Decision{Action: ActionSuspend, Reason: "abuse"}, false, ErrInvalidDuration},
+		{"ban", Decision{Action: ActionBan, Reason: "abuse"}, true, nil},
+		{"ban without reason", Decision{Action: ActionBan}, false, ErrReasonRequired},
+		{"shadow ban", Decision{Action: ActionShadowBan, Reason: "spam"}, false, nil},
+		{"lift from report", Decision{Action: ActionLiftSuspension, Reason: "sorry"}, false, ErrUnknownAction},
+		{"unknown", Decision{Action: "delete", Reason: "abuse"}, true, ErrUnknownAction},
+	}
+
+	for _, tt := range tests {
+		if err := tt.decision.Check(tt.chirpReport); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestAccountCheck(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	if err := (Account{}).Check(now); err != nil {
+		t.Fatalf("clean account rejected: %v", err)
+	}
+
+	err := (Account{SuspendedUntil: now.Add(time.Hour), SuspensionReason: "spam"}).Check(now)
+	if !errors.Is(err, ErrSuspended) {
+		t.Fatalf("expected ErrSuspended, got %v", err)
+	}
+
+	var r *Restriction
+	if !errors.As(err, &r) || r.Reason != "spam" || !r.Until.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected restriction %+v", r)
+	}
+
+	if err := (Account{SuspendedUntil: now.Add(-time.Hour)}).Check(now); err != nil {
+		t.Fatalf("lapsed suspension rejected: %v", err)
+	}
+
+	if err := (Account{BannedAt: now.Add(-time.Hour), SuspendedUntil: now.Add(time.Hour)}).Check(now); !errors.Is(err, ErrBanned) {
+		t.Fatalf("expected ErrBanned, got %v", err)
+	}
+
+	if err := (Account{ShadowBanned: true}).Check(now); err != nil {
+		t.Fatalf("shadow banned account rejected: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeProvider is a minimal OIDC provider. It issues one code per
+// authorization request and checks PKCE when the code is redeemed.
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// sign picks the key the next ID token is signed with.
+	sign string
+
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating rsa key %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating ec key %v", err)
+	}
+
+	f := &fakeProvider{t: t, rsaKey: rsaKey, ecKey: ecKey, sign: "rsa"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                f.server.URL,
+			AuthorizationEndpoint: f.server.URL + "/authorize",
+			TokenEndpoint:         f.server.URL + "/token",
+			JWKSURI:               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{
+			{Kty: "RSA", Kid: "rsa", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "the-code" || S256Challenge(r.FormValue("code_verifier")) != f.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.idToken()})
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeProvider) idToken() string {
+	claims := jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            "client",
+		"sub":            "user-1",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"nonce":          f.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range f.claims {
+		claims[k] = v
+	}
+
+	var token *jwt.Token
+	var key any
+	if f.sign == "ec" {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodES256, claims), f.ecKey
+	} else {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodRS256, claims), f.rsaKey
+	}
+	token.Header["kid"] = f.sign
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatalf("failed signing id token %v", err)
+	}
+	return signed
+}
+
+// authorize plays the browser: it follows the authorization URL and
+// records what the provider would remember about the request.
+func (f *fakeProvider) authorize(authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatalf("bad auth url %v", err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" {
+		f.t.Fatalf("unexpected auth url %s", authURL)
+	}
+
+	f.challenge = q.Get("code_challenge")
+	f.nonce = q.Get("nonce")
+}
+
+func login(t *testing.T, f *fakeProvider, p *Provider, nonce string) (Identity, error) {
+	ctx := context.Background()
+
+	verifier, err := GenerateVerifier()
+	if err != nil {
+		t.Fatalf("failed generating verifier %v", err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("failed building auth url %v", err)
+	}
+	f.authorize(authURL)
+
+	raw, err := p.Exchange(ctx, "the-code", verifier)
+	if err != nil {
+		t.Fatalf("failed exchanging code %v", err)
+	}
+
+	return p.VerifyIDToken(ctx, raw, nonce)
+}
+
+func newTestProvider(f *fakeProvider) *Provider {
+	return NewProvider(Config{
+		Name:         "fake",
+		Issuer:       f.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+		Scopes:       []string{"email"},
+	})
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeProvider(t)
+	p := newTestProvider(f)
+
+	for _, sign := range []string{"rsa", "ec"} {
+		f.sign = sign
+
+		id, err := login(t, f, p, "nonce-"+sign)
+		if err != nil {
+			t.Fatalf("%s: login failed %v", sign, err)
+		}
+
+		if id.Subject != "user-1" || id.Email != "user@example.com" || !id.EmailVerified {
+			t.Fatalf("%s: unexpected identity %+v", sign, id)
+		}
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	f := newFakeProvider(t)
+	p := newTestProvider(f)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier-one")
+	if err != nil {
+		t.Fatalf("failed building auth url %v", err)
+	}
+	f.authorize(authURL)
+
+	_, err = p.Exchange(context.Background(), "the-code", "verifier-two")
+	if !errors.Is(err, ErrTokenExchange) {
+		t.Fatalf("expected ErrTokenExchange, got %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		nonce  string
+		want   error
+	}{
+		{"nonce", nil, "other-nonce", ErrNonceMismatch},
+		{"audience", jwt.MapClaims{"aud": "someone-else"}, "", ErrInvalidIDToken},
+		{"issuer", jwt.MapClaims{"iss": "https://evil.example"}, "", ErrInvalidIDToken},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, "", ErrInvalidIDToken},
+		{"subject", jwt.MapClaims{"sub": ""}, "", ErrInvalidIDToken},
+	}
+
+	for _, tt := range tests {
+		f := newFakeProvider(t)
+		p := newTestProvider(f)
+		f.claims = tt.claims
+
+		ctx := context.Background()
+		verifier, _ := GenerateVerifier()
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+		f.authorize(authURL)
+
+		raw, err := p.Exchange(ctx, "the-code", verifier)
+		if err != nil {
+			t.Fatalf("%s: failed exchanging code %v", tt.name, err)
+		}
+
+		nonce := tt.nonce
+		if nonce == "" {
+			nonce = "nonce"
+		}
+
+		_, err = p.VerifyIDToken(ctx, raw, nonce)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenBadSignature(t *testing.T) {
+	f := newFakeProvider(t)
+	p := newTestProvider(f)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating rsa key %v", err)
+	}
+	f.rsaKey = other
+
+	_, err = login(t, f, p, "nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+}
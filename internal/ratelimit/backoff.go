@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the number of tracked keys above which Fail clears out
+// entries that have gone quiet, so a spray of distinct keys cannot grow the
+// map without bound.
+const sweepThreshold = 10000
+
+type backoffEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Backoff tracks consecutive failures per key. Once a key has failed
+// threshold times, each further failure locks it out for twice as long as
+// the last, starting at base and capped at max. A key that has not failed
+// for window is forgotten.
+type Backoff struct {
+	mu        sync.Mutex
+	entries   map[string]*backoffEntry
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	window    time.Duration
+	now       func() time.Time
+}
+
+func NewBackoff(threshold int, base, max, window time.Duration) *Backoff {
+	return &Backoff{
+		entries:   map[string]*backoffEntry{},
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		window:    window,
+		now:       time.Now,
+	}
+}
+
+// Blocked reports whether key is locked out and for how much longer.
+func (b *Backoff) Blocked(key string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := b.entry(key, b.now())
+	if e == nil {
+		return 0, false
+	}
+
+	remaining := e.lockedUntil.Sub(b.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+// Fail records a failure for key and returns the lockout it triggered, if
+// any.
+func (b *Backoff) Fail(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	if len(b.entries) > sweepThreshold {
+		b.sweep(now)
+	}
+
+	e := b.entry(key, now)
+	if e == nil {
+		e = &backoffEntry{}
+		b.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures < b.threshold {
+		return 0
+	}
+
+	lockout := b.base
+	for i := b.threshold; i < e.failures && lockout < b.max; i++ {
+		lockout *= 2
+	}
+	lockout = min(lockout, b.max)
+
+	e.lockedUntil = now.Add(lockout)
+
+	return lockout
+}
+
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.entries, key)
+}
+
+// entry returns the live entry for key, dropping it if it has expired.
+func (b *Backoff) entry(key string, now time.Time) *backoffEntry {
+	e, ok := b.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if b.expired(e, now) {
+		delete(b.entries, key)
+		return nil
+	}
+
+	return e
+}
+
+func (b *Backoff) expired(e *backoffEntry, now time.Time) bool {
+	return now.After(e.lastFailure.Add(b.window)) && now.After(e.lockedUntil)
+}
+
+func (b *Backoff) sweep(now time.Time) {
+	for key, e := range b.entries {
+		if b.expired(e, now) {
+			delete(b.entries, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Now()
+	b := NewBackoff(3, time.Second, 4*time.Second, time.Minute)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if lockout := b.Fail("walt"); lockout != 0 {
+			t.Fatalf("locked out after %d failures", i+1)
+		}
+	}
+
+	if _, blocked := b.Blocked("walt"); blocked {
+		t.Fatalf("blocked before threshold")
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for _, w := range want {
+		if lockout := b.Fail("walt"); lockout != w {
+			t.Fatalf("expected lockout %s, got %s", w, lockout)
+		}
+	}
+
+	if remaining, blocked := b.Blocked("walt"); !blocked || remaining != 4*time.Second {
+		t.Fatalf("expected 4s lockout, got %s %v", remaining, blocked)
+	}
+
+	if _, blocked := b.Blocked("jesse"); blocked {
+		t.Fatalf("unrelated key blocked")
+	}
+
+	now = now.Add(5 * time.Second)
+
+	if _, blocked := b.Blocked("walt"); blocked {
+		t.Fatalf("still blocked after lockout elapsed")
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if lockout := b.Fail("walt"); lockout != 0 {
+		t.Fatalf("failures not forgotten after window, got lockout %s", lockout)
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := NewBackoff(1, time.Minute, time.Hour, time.Hour)
+
+	b.Fail("walt")
+
+	if _, blocked := b.Blocked("walt"); !blocked {
+		t.Fatalf("expected key to be blocked")
+	}
+
+	b.Reset("walt")
+
+	if _, blocked := b.Blocked("walt"); blocked {
+		t.Fatalf("expected reset to clear lockout")
+	}
+}
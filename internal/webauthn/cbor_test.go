@@ -0,0 +1,69 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949 appendix A.
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		in, _ := hex.DecodeString(tt.in)
+
+		got, n, err := decodeCBOR(in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+
+		if n != len(in) {
+			t.Fatalf("%s: consumed %d of %d bytes", tt.in, n, len(in))
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	tests := []string{
+		"",                   // empty
+		"18",                 // truncated argument
+		"44010203",           // truncated byte string
+		"9b00000000ffffffff", // absurd array length
+		"f93c00",             // half float
+		"5f42010243030405ff", // indefinite length
+		"a1f601",             // null map key
+	}
+
+	for _, tt := range tests {
+		in, _ := hex.DecodeString(tt)
+
+		_, _, err := decodeCBOR(in)
+		if err == nil {
+			t.Fatalf("%q: expected error", tt)
+		}
+	}
+}
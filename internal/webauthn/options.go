@@ -0,0 +1,103 @@
+package webauthn
+
+// The types below mirror PublicKeyCredentialCreationOptions and
+// PublicKeyCredentialRequestOptions, so they can be handed to the browser
+// API once the binary fields are decoded.
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string          `json:"type"`
+	ID   URLEncodedBytes `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              URLEncodedBytes        `json:"challenge"`
+	RP                     relyingParty           `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        URLEncodedBytes        `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// ceremonyTimeout is in milliseconds, as the browser API expects.
+const ceremonyTimeout = 5 * 60 * 1000
+
+func (c Config) userVerification() string {
+	if c.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// CreationOptions asks for a discoverable credential, so the user can later
+// sign in without typing their email. existing lists credentials the user
+// already has, so the same authenticator is not registered twice.
+func (c Config) CreationOptions(challenge, userID []byte, userName string, existing [][]byte) CreationOptions {
+	exclude := []CredentialDescriptor{}
+	for _, id := range existing {
+		exclude = append(exclude, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        relyingParty{ID: c.RPID, Name: c.RPName},
+		User: userEntity{
+			ID:          userID,
+			Name:        userName,
+			DisplayName: userName,
+		},
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            ceremonyTimeout,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: c.userVerification(),
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions leaves allowCredentials empty, letting the browser offer
+// whichever passkeys it holds for Chirpy.
+func (c Config) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             c.RPID,
+		Timeout:          ceremonyTimeout,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: c.userVerification(),
+	}
+}
@@ -0,0 +1,362 @@
+// Package webauthn verifies passkey registrations and assertions. It only
+// supports the "none" attestation format, and ES256 and RS256 keys.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidClientData  = errors.New("invalid client data")
+	ErrChallengeMismatch  = errors.New("challenge mismatch")
+	ErrOriginMismatch     = errors.New("origin not allowed")
+	ErrInvalidAuthData    = errors.New("invalid authenticator data")
+	ErrUserNotPresent     = errors.New("user presence not asserted")
+	ErrUserNotVerified    = errors.New("user verification required")
+	ErrUnsupportedFormat  = errors.New("unsupported attestation format")
+	ErrUnsupportedKey     = errors.New("unsupported credential key")
+	ErrBadSignature       = errors.New("signature verification failed")
+	ErrSignCountRegressed = errors.New("sign count did not increase")
+)
+
+// COSE algorithm identifiers.
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagAttestedData  = 0x40
+	flagExtensionData = 0x80
+)
+
+// Config describes the relying party, that is Chirpy.
+type Config struct {
+	RPID   string
+	RPName string
+	// Origins the browser may report, such as https://chirpy.example.
+	Origins []string
+	// RequireUserVerification demands a PIN or biometric check on top of
+	// presence. Passkeys replace passwords, so this should normally be on.
+	RequireUserVerification bool
+}
+
+// URLEncodedBytes marshals to unpadded base64url, the encoding the
+// WebAuthn browser API uses for binary fields.
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// Credential is what is stored for a registered passkey.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded key, kept as sent.
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (c Config) verifyClientData(raw []byte, wantType string, challenge []byte) error {
+	var cd clientData
+	err := json.Unmarshal(raw, &cd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidClientData, err)
+	}
+
+	if cd.Type != wantType {
+		return fmt.Errorf("%w: type %q", ErrInvalidClientData, cd.Type)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if !slices.Contains(c.Origins, cd.Origin) {
+		return fmt.Errorf("%w: %s", ErrOriginMismatch, cd.Origin)
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, fmt.Errorf("%w: too short", ErrInvalidAuthData)
+	}
+
+	ad := authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.Flags&flagAttestedData != 0 {
+		// AAGUID, then a two byte length and the credential ID.
+		if len(rest) < 18 {
+			return authenticatorData{}, fmt.Errorf("%w: truncated attested credential", ErrInvalidAuthData)
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return authenticatorData{}, fmt.Errorf("%w: truncated credential id", ErrInvalidAuthData)
+		}
+		ad.CredentialID, rest = rest[:n], rest[n:]
+
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidAuthData, err)
+		}
+		ad.PublicKey, rest = rest[:used], rest[used:]
+	}
+
+	if ad.Flags&flagExtensionData != 0 {
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidAuthData, err)
+		}
+		rest = rest[used:]
+	}
+
+	if len(rest) != 0 {
+		return authenticatorData{}, fmt.Errorf("%w: trailing bytes", ErrInvalidAuthData)
+	}
+
+	return ad, nil
+}
+
+func (c Config) checkAuthenticatorData(ad authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: relying party id mismatch", ErrInvalidAuthData)
+	}
+
+	if ad.Flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+
+	if c.RequireUserVerification && ad.Flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create
+// and returns the new credential.
+func (c Config) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %w", ErrInvalidAuthData, err)
+	}
+
+	att, ok := decoded.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object is not a map", ErrInvalidAuthData)
+	}
+
+	if f, _ := att["fmt"].(string); f != "none" {
+		return Credential{}, fmt.Errorf("%w: %q", ErrUnsupportedFormat, f)
+	}
+
+	rawAuthData, ok := att["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authData", ErrInvalidAuthData)
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	err = c.checkAuthenticatorData(ad)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if ad.CredentialID == nil {
+		return Credential{}, fmt.Errorf("%w: no attested credential", ErrInvalidAuthData)
+	}
+
+	_, _, err = parsePublicKey(ad.PublicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:        slices.Clone(ad.CredentialID),
+		PublicKey: slices.Clone(ad.PublicKey),
+		SignCount: ad.SignCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get made
+// with cred, and returns the authenticator's new sign count.
+func (c Config) VerifyAssertion(challenge []byte, cred Credential, clientDataJSON, authData, signature []byte) (uint32, error) {
+	err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.checkAuthenticatorData(ad)
+	if err != nil {
+		return 0, err
+	}
+
+	alg, pub, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+
+	switch alg {
+	case AlgES256:
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signature) {
+			return 0, ErrBadSignature
+		}
+	case AlgRS256:
+		if rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) != nil {
+			return 0, ErrBadSignature
+		}
+	}
+
+	// Authenticators that do not count always report zero. Otherwise the
+	// count must go up, or the credential may have been cloned.
+	if (ad.SignCount != 0 || cred.SignCount != 0) && ad.SignCount <= cred.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+
+	return ad.SignCount, nil
+}
+
+// COSE key parameters, RFC 9053.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+	coseP256   = 1
+)
+
+func parsePublicKey(raw []byte) (int64, any, error) {
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: not a map", ErrUnsupportedKey)
+	}
+
+	kty, _ := key[int64(coseKty)].(int64)
+	alg, _ := key[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := key[int64(coseCrv)].(int64)
+		x, _ := key[int64(coseX)].([]byte)
+		y, _ := key[int64(coseY)].([]byte)
+		if crv != coseP256 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, fmt.Errorf("%w: bad EC2 key", ErrUnsupportedKey)
+		}
+
+		// Going through the uncompressed point form makes the standard
+		// library check the point is on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return 0, nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+
+		return alg, &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := key[int64(coseRSAN)].([]byte)
+		e, _ := key[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, fmt.Errorf("%w: bad RSA key", ErrUnsupportedKey)
+		}
+
+		return alg, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	default:
+		return 0, nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+	}
+}
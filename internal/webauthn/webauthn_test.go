@@ -0,0 +1,307 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR is just enough of an encoder to play an authenticator.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		keys := [][]byte{}
+		for k := range v {
+			keys = append(keys, encodeCBOR(k))
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			key, _, _ := decodeCBOR(k)
+			if i, ok := key.(int64); ok {
+				key = int(i)
+			}
+			out = append(append(out, k...), encodeCBOR(v[key])...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+type fakeAuthenticator struct {
+	rpID      string
+	origin    string
+	credID    []byte
+	signCount uint32
+	flags     byte
+	ecKey     *ecdsa.PrivateKey
+	rsaKey    *rsa.PrivateKey
+}
+
+func newFakeAuthenticator(t *testing.T, alg int) *fakeAuthenticator {
+	a := &fakeAuthenticator{
+		rpID:   "chirpy.example",
+		origin: "https://chirpy.example",
+		credID: make([]byte, 16),
+		flags:  flagUserPresent | flagUserVerified,
+	}
+
+	_, err := rand.Read(a.credID)
+	if err != nil {
+		t.Fatalf("failed generating credential id %v", err)
+	}
+
+	if alg == AlgRS256 {
+		a.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("failed generating key %v", err)
+	}
+
+	return a
+}
+
+func (a *fakeAuthenticator) coseKey() []byte {
+	if a.rsaKey != nil {
+		return encodeCBOR(map[any]any{
+			coseKty:  coseKtyRSA,
+			coseAlg:  AlgRS256,
+			coseRSAN: a.rsaKey.N.Bytes(),
+			coseRSAE: big.NewInt(int64(a.rsaKey.E)).Bytes(),
+		})
+	}
+
+	return encodeCBOR(map[any]any{
+		coseKty: coseKtyEC2,
+		coseAlg: AlgES256,
+		coseCrv: coseP256,
+		coseX:   a.ecKey.X.FillBytes(make([]byte, 32)),
+		coseY:   a.ecKey.Y.FillBytes(make([]byte, 32)),
+	})
+}
+
+func (a *fakeAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+func (a *fakeAuthenticator) clientData(typ string, challenge []byte) []byte {
+	b, _ := json.Marshal(clientData{
+		Type:      typ,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return b
+}
+
+func (a *fakeAuthenticator) create(challenge []byte) (clientDataJSON, attestationObject []byte) {
+	return a.clientData("webauthn.create", challenge), encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(true),
+	})
+}
+
+func (a *fakeAuthenticator) get(t *testing.T, challenge []byte) (clientDataJSON, authData, signature []byte) {
+	a.signCount++
+
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	var err error
+	if a.rsaKey != nil {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, a.rsaKey, crypto.SHA256, digest[:])
+	} else {
+		signature, err = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	}
+	if err != nil {
+		t.Fatalf("failed signing assertion %v", err)
+	}
+
+	return clientDataJSON, authData, signature
+}
+
+var testConfig = Config{
+	RPID:                    "chirpy.example",
+	RPName:                  "Chirpy",
+	Origins:                 []string{"https://chirpy.example"},
+	RequireUserVerification: true,
+}
+
+func register(t *testing.T, a *fakeAuthenticator) Credential {
+	challenge, _ := NewChallenge()
+	clientDataJSON, attestationObject := a.create(challenge)
+
+	cred, err := testConfig.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("registration failed %v", err)
+	}
+
+	return cred
+}
+
+func TestRegisterAndAssert(t *testing.T) {
+	for _, alg := range []int{AlgES256, AlgRS256} {
+		a := newFakeAuthenticator(t, alg)
+		cred := register(t, a)
+
+		if !bytes.Equal(cred.ID, a.credID) {
+			t.Fatalf("alg %d: unexpected credential id %x", alg, cred.ID)
+		}
+
+		for range 2 {
+			challenge, _ := NewChallenge()
+			clientDataJSON, authData, signature := a.get(t, challenge)
+
+			count, err := testConfig.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+			if err != nil {
+				t.Fatalf("alg %d: assertion failed %v", alg, err)
+			}
+
+			if count != a.signCount {
+				t.Fatalf("alg %d: sign count %d, want %d", alg, count, a.signCount)
+			}
+			cred.SignCount = count
+		}
+	}
+}
+
+func TestAssertionRejects(t *testing.T) {
+	a := newFakeAuthenticator(t, AlgES256)
+	cred := register(t, a)
+
+	challenge, _ := NewChallenge()
+	clientDataJSON, authData, signature := a.get(t, challenge)
+
+	other, _ := NewChallenge()
+	_, err := testConfig.VerifyAssertion(other, cred, clientDataJSON, authData, signature)
+	if !errors.Is(err, ErrChallengeMismatch) {
+		t.Fatalf("expected ErrChallengeMismatch, got %v", err)
+	}
+
+	tampered := bytes.Clone(signature)
+	tampered[len(tampered)-1] ^= 1
+	_, err = testConfig.VerifyAssertion(challenge, cred, clientDataJSON, authData, tampered)
+	if !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+
+	cred.SignCount = a.signCount
+	_, err = testConfig.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	if !errors.Is(err, ErrSignCountRegressed) {
+		t.Fatalf("expected ErrSignCountRegressed, got %v", err)
+	}
+
+	a.origin = "https://evil.example"
+	challenge, _ = NewChallenge()
+	clientDataJSON, authData, signature = a.get(t, challenge)
+	_, err = testConfig.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	if !errors.Is(err, ErrOriginMismatch) {
+		t.Fatalf("expected ErrOriginMismatch, got %v", err)
+	}
+
+	a.origin = "https://chirpy.example"
+	a.flags = flagUserPresent
+	challenge, _ = NewChallenge()
+	clientDataJSON, authData, signature = a.get(t, challenge)
+	_, err = testConfig.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	if !errors.Is(err, ErrUserNotVerified) {
+		t.Fatalf("expected ErrUserNotVerified, got %v", err)
+	}
+}
+
+func TestRegistrationRejects(t *testing.T) {
+	a := newFakeAuthenticator(t, AlgES256)
+	challenge, _ := NewChallenge()
+
+	a.rpID = "evil.example"
+	clientDataJSON, attestationObject := a.create(challenge)
+	_, err := testConfig.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if !errors.Is(err, ErrInvalidAuthData) {
+		t.Fatalf("expected ErrInvalidAuthData, got %v", err)
+	}
+
+	a.rpID = "chirpy.example"
+	clientDataJSON, _ = a.create(challenge)
+	packed := encodeCBOR(map[any]any{
+		"fmt":      "packed",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(true),
+	})
+	_, err = testConfig.VerifyRegistration(challenge, clientDataJSON, packed)
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+
+	_, err = testConfig.VerifyRegistration(challenge, a.clientData("webauthn.get", challenge), packed)
+	if !errors.Is(err, ErrInvalidClientData) {
+		t.Fatalf("expected ErrInvalidClientData, got %v", err)
+	}
+}
+
+func TestURLEncodedBytes(t *testing.T) {
+	b, err := json.Marshal(URLEncodedBytes{0xfb, 0xff})
+	if err != nil || string(b) != `"-_8"` {
+		t.Fatalf("unexpected encoding %s %v", b, err)
+	}
+
+	var decoded URLEncodedBytes
+	err = json.Unmarshal([]byte(`"-_8="`), &decoded)
+	if err != nil || !bytes.Equal(decoded, []byte{0xfb, 0xff}) {
+		t.Fatalf("unexpected decoding %x %v", decoded, err)
+	}
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// EventHeader names the event type of an outgoing delivery, so receivers can
+// route it without parsing the body.
+const EventHeader = "Webhook-Event"
+
+var (
+	ErrUnexpectedStatus = errors.New("webhook receiver returned an error status")
+	ErrForbiddenAddress = errors.New("webhook target address not allowed")
+)
+
+type Delivery struct {
+	URL       string
+	Secret    string
+	EventID   string
+	EventType string
+	Payload   []byte
+}
+
+// Sender posts signed deliveries to receivers.
+type Sender struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewSender returns a Sender that gives up on a receiver after timeout.
+// Unless allowPrivate is set it refuses to connect to loopback, private and
+// link-local addresses, so a registered URL cannot be used to reach
+// services inside our network.
+func NewSender(timeout time.Duration, allowPrivate bool) *Sender {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		// Checked on the resolved address at connect time, so a hostname
+		// that later resolves somewhere private is caught too.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		}
+	}
+
+	return &Sender{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:       nil,
+				DialContext: dialer.DialContext,
+			},
+			// A redirect would send the signed payload somewhere the user
+			// did not register.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now: time.Now,
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// Send delivers d and returns the receiver's status code. Any status
+// outside 2xx is returned with ErrUnexpectedStatus.
+func (s *Sender) Send(ctx context.Context, d Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, d.EventType)
+	Sign(req.Header, d.Secret, d.EventID, s.now(), d.Payload)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Drain a little so the connection can be reused, without letting a
+	// receiver make us read forever.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// RetryPolicy spaces out attempts at a failing delivery, doubling the wait
+// each time from Base up to Max.
+type RetryPolicy struct {
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	Base:        30 * time.Second,
+	Max:         6 * time.Hour,
+}
+
+// Next returns how long to wait after the given number of failed attempts.
+// It returns false once the attempts are used up and the delivery should be
+// dead-lettered.
+func (p RetryPolicy) Next(attempts int) (time.Duration, bool) {
+	if attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	wait := p.Base
+	for i := 1; i < attempts && wait < p.Max; i++ {
+		wait *= 2
+	}
+
+	return min(wait, p.Max), true
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	verifier := NewVerifier([]string{"endpoint-secret"}, time.Minute)
+	payload := []byte(`{"type":"chirp.created"}`)
+
+	var verifyErr error
+	var eventType string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, verifyErr = verifier.Verify(r.Header, body)
+		eventType = r.Header.Get(EventHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer receiver.Close()
+
+	sender := NewSender(time.Second, true)
+
+	status, err := sender.Send(context.Background(), Delivery{
+		URL:       receiver.URL,
+		Secret:    "endpoint-secret",
+		EventID:   "evt_1",
+		EventType: "chirp.created",
+		Payload:   payload,
+	})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	if verifyErr != nil {
+		t.Fatalf("receiver could not verify delivery: %v", verifyErr)
+	}
+
+	if eventType != "chirp.created" {
+		t.Fatalf("unexpected event header %q", eventType)
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/redirect":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer receiver.Close()
+
+	sender := NewSender(100*time.Millisecond, true)
+
+	for _, path := range []string{"/error", "/redirect"} {
+		_, err := sender.Send(context.Background(), Delivery{URL: receiver.URL + path, Payload: []byte(`{}`)})
+		if !errors.Is(err, ErrUnexpectedStatus) {
+			t.Fatalf("%s: expected ErrUnexpectedStatus, got %v", path, err)
+		}
+	}
+
+	_, err := sender.Send(context.Background(), Delivery{URL: receiver.URL + "/slow", Payload: []byte(`{}`)})
+	if err == nil {
+		t.Fatalf("expected timeout")
+	}
+
+	// The receiver is on loopback, which is off limits by default.
+	_, err = NewSender(time.Second, false).Send(context.Background(), Delivery{URL: receiver.URL, Payload: []byte(`{}`)})
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected ErrForbiddenAddress, got %v", err)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, Base: time.Second, Max: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		got, ok := p.Next(i + 1)
+		if !ok || got != w {
+			t.Fatalf("attempt %d: got %s %t, want %s", i+1, got, ok, w)
+		}
+	}
+
+	if _, ok := p.Next(5); ok {
+		t.Fatalf("expected delivery to be dead-lettered after max attempts")
+	}
+}
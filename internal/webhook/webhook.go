@@ -0,0 +1,194 @@
+// Package webhook signs, verifies and sends webhook requests. The signature
+// is an HMAC-SHA256 over the event ID, a timestamp and the raw body, so none
+// of them can be altered or replayed outside a short window.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IDHeader        = "Webhook-Id"
+	TimestampHeader = "Webhook-Timestamp"
+	// SignatureHeader holds one or more space separated "v1=<hex>"
+	// signatures, so a sender can sign with old and new secrets while
+	// rotating.
+	SignatureHeader = "Webhook-Signature"
+)
+
+var (
+	ErrMissingSignature = errors.New("webhook signature missing")
+	ErrMalformedHeader  = errors.New("malformed webhook header")
+	ErrTimestamp        = errors.New("webhook timestamp outside tolerance")
+	ErrBadSignature     = errors.New("webhook signature mismatch")
+	ErrReplayed         = errors.New("webhook event already received")
+)
+
+func mac(secret []byte, eventID, timestamp string, body []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(eventID))
+	m.Write([]byte{'.'})
+	m.Write([]byte(timestamp))
+	m.Write([]byte{'.'})
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Sign sets the webhook headers on h for body.
+func Sign(h http.Header, secret, eventID string, t time.Time, body []byte) {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	h.Set(IDHeader, eventID)
+	h.Set(TimestampHeader, timestamp)
+	h.Set(SignatureHeader, "v1="+hex.EncodeToString(mac([]byte(secret), eventID, timestamp, body)))
+}
+
+// Verifier checks incoming webhooks against any of several secrets.
+type Verifier struct {
+	secrets   [][]byte
+	tolerance time.Duration
+	replays   *ReplayCache
+	now       func() time.Time
+}
+
+// NewVerifier accepts signatures made with any of secrets, with timestamps
+// up to tolerance away from now.
+func NewVerifier(secrets []string, tolerance time.Duration) *Verifier {
+	v := &Verifier{
+		tolerance: tolerance,
+		// Anything older than the tolerance fails the timestamp check, so
+		// IDs only need remembering for that long either side of now.
+		replays: NewReplayCache(2 * tolerance),
+		now:     time.Now,
+	}
+
+	for _, s := range secrets {
+		if s != "" {
+			v.secrets = append(v.secrets, []byte(s))
+		}
+	}
+
+	return v
+}
+
+// Verify checks the signature and timestamp headers against body, and
+// that the event ID has not been seen before. It returns the event ID.
+func (v *Verifier) Verify(h http.Header, body []byte) (string, error) {
+	eventID := h.Get(IDHeader)
+	timestamp := h.Get(TimestampHeader)
+	signatures := h.Get(SignatureHeader)
+
+	if signatures == "" {
+		return "", ErrMissingSignature
+	}
+
+	if eventID == "" || timestamp == "" {
+		return "", fmt.Errorf("%w: missing id or timestamp", ErrMalformedHeader)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: timestamp", ErrMalformedHeader)
+	}
+
+	skew := v.now().Sub(time.Unix(unix, 0))
+	if skew > v.tolerance || skew < -v.tolerance {
+		return "", ErrTimestamp
+	}
+
+	if !v.matches(signatures, eventID, timestamp, body) {
+		return "", ErrBadSignature
+	}
+
+	if !v.replays.Add(eventID, v.now()) {
+		return "", ErrReplayed
+	}
+
+	return eventID, nil
+}
+
+func (v *Verifier) matches(signatures, eventID, timestamp string, body []byte) bool {
+	expected := make([][]byte, 0, len(v.secrets))
+	for _, secret := range v.secrets {
+		expected = append(expected, mac(secret, eventID, timestamp, body))
+	}
+
+	for _, sig := range strings.Fields(signatures) {
+		version, value, ok := strings.Cut(sig, "=")
+		if !ok || version != "v1" {
+			continue
+		}
+
+		got, err := hex.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range expected {
+			if hmac.Equal(got, e) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Forget lets an event be delivered again, for when it was verified but
+// could not be processed and the sender should retry.
+func (v *Verifier) Forget(eventID string) {
+	v.replays.Remove(eventID)
+}
+
+// ReplayCache remembers IDs for a fixed time.
+type ReplayCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func NewReplayCache(ttl time.Duration) *ReplayCache {
+	return &ReplayCache{
+		ttl:  ttl,
+		seen: map[string]time.Time{},
+	}
+}
+
+// Add records id and reports whether it was new.
+func (c *ReplayCache) Add(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.pruned) > c.ttl {
+		for k, t := range c.seen {
+			if now.Sub(t) > c.ttl {
+				delete(c.seen, k)
+			}
+		}
+		c.pruned = now
+	}
+
+	if t, ok := c.seen[id]; ok && now.Sub(t) <= c.ttl {
+		return false
+	}
+
+	c.seen[id] = now
+	return true
+}
+
+func (c *ReplayCache) Remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.seen, id)
+}
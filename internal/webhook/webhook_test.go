@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded"}`)
+
+	v := NewVerifier([]string{"old-secret", "new-secret"}, 5*time.Minute)
+	v.now = func() time.Time { return now }
+
+	for i, secret := range []string{"old-secret", "new-secret"} {
+		h := http.Header{}
+		Sign(h, secret, "evt_"+string(rune('a'+i)), now, body)
+
+		id, err := v.Verify(h, body)
+		if err != nil {
+			t.Fatalf("%s: %v", secret, err)
+		}
+
+		if id != h.Get(IDHeader) {
+			t.Fatalf("unexpected event id %q", id)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded"}`)
+
+	v := NewVerifier([]string{"secret"}, 5*time.Minute)
+	v.now = func() time.Time { return now }
+
+	sign := func(secret, id string, at time.Time) http.Header {
+		h := http.Header{}
+		Sign(h, secret, id, at, body)
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		want   error
+	}{
+		{"unsigned", http.Header{}, body, ErrMissingSignature},
+		{"wrong secret", sign("other", "evt_1", now), body, ErrBadSignature},
+		{"altered body", sign("secret", "evt_2", now), []byte(`{"event":"user.downgraded"}`), ErrBadSignature},
+		{"too old", sign("secret", "evt_3", now.Add(-6*time.Minute)), body, ErrTimestamp},
+		{"too new", sign("secret", "evt_4", now.Add(6*time.Minute)), body, ErrTimestamp},
+	}
+
+	for _, tt := range tests {
+		_, err := v.Verify(tt.header, tt.body)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// The event ID is signed, so it cannot be swapped to dodge the replay
+	// check.
+	h := sign("secret", "evt_5", now)
+	h.Set(IDHeader, "evt_6")
+	if _, err := v.Verify(h, body); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for swapped id, got %v", err)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{}`)
+
+	v := NewVerifier([]string{"secret"}, 5*time.Minute)
+	v.now = func() time.Time { return now }
+
+	h := http.Header{}
+	Sign(h, "secret", "evt_1", now, body)
+
+	if _, err := v.Verify(h, body); err != nil {
+		t.Fatalf("first delivery rejected: %v", err)
+	}
+
+	if _, err := v.Verify(h, body); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected ErrReplayed, got %v", err)
+	}
+
+	v.Forget("evt_1")
+
+	if _, err := v.Verify(h, body); err != nil {
+		t.Fatalf("forgotten event rejected: %v", err)
+	}
+}
+
+func TestReplayCacheExpires(t *testing.T) {
+	c := NewReplayCache(time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	if !c.Add("a", now) {
+		t.Fatalf("new id reported as seen")
+	}
+
+	if c.Add("a", now.Add(30*time.Second)) {
+		t.Fatalf("repeated id reported as new")
+	}
+
+	if !c.Add("a", now.Add(2*time.Minute)) {
+		t.Fatalf("expired id reported as seen")
+	}
+}
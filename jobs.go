@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runPeriodic calls fn straight away and then every interval until ctx is
+// cancelled. Errors are logged and the job carries on.
+func runPeriodic(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := fn(ctx)
+		if err != nil {
+			log.Printf("job %s failed: %s", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) startJobs(ctx context.Context) {
+	go runPeriodic(ctx, "purge deleted users", time.Hour, cfg.purgeDeletedUsers)
+	go runPeriodic(ctx, "build data exports", 30*time.Second, cfg.buildPendingExports)
+	go runPeriodic(ctx, "delete expired data exports", time.Hour, cfg.deleteExpiredExports)
+	go runPeriodic(ctx, "delete expired oidc login states", time.Hour, cfg.deleteExpiredOIDCLoginStates)
+	go runPeriodic(ctx, "delete expired oauth codes", time.Hour, cfg.deleteExpiredOAuthCodes)
+	go runPeriodic(ctx, "delete expired passkey challenges", time.Hour, cfg.deleteExpiredPasskeyChallenges)
+	go runPeriodic(ctx, "expire lapsed subscriptions", 10*time.Minute, cfg.expireSubscriptions)
+	go runPeriodic(ctx, "deliver webhooks", webhookDeliveryPeriod, cfg.deliverWebhooks)
+	go runPeriodic(ctx, "announce scheduled chirps", chirpAnnouncePeriod, cfg.announceScheduledChirps)
+	go runPeriodic(ctx, "reload content filter", contentFilterReloadPeriod, cfg.reloadContentFilter)
+}
+
+// purgeDeletedUsers removes accounts whose grace period is over. Their
+// exports are deleted first, in the same transaction, so the files can be
+// removed from disk rather than left behind by the cascade.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	exports, err := qtx.DeletePurgeableDataExports(ctx)
+	if err != nil {
+		return err
+	}
+
+	n, err := qtx.PurgeDeletedUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	removeExportFiles(exports)
+
+	if n > 0 {
+		log.Printf("purged %d deleted users", n)
+	}
+
+	return nil
+}
@@ -21,6 +21,24 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+// fieldError is one problem with one field of a request body.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func respondWithValidationError(w http.ResponseWriter, msg string, details []fieldError) {
+	type validationResponse struct {
+		Error   string       `json:"error"`
+		Details []fieldError `json:"details"`
+	}
+	respondWithJSON(w, http.StatusUnprocessableEntity, validationResponse{
+		Error:   msg,
+		Details: details,
+	})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/w0/chirpy/internal/ratelimit"
+)
+
+// loginThrottle slows down password guessing. Failures are counted both per
+// account, to protect a targeted user, and per client IP, to catch one
+// client spraying many accounts.
+type loginThrottle struct {
+	accounts *ratelimit.Backoff
+	ips      *ratelimit.Backoff
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		accounts: ratelimit.NewBackoff(5, 30*time.Second, 15*time.Minute, time.Hour),
+		ips:      ratelimit.NewBackoff(20, 30*time.Second, time.Hour, time.Hour),
+	}
+}
+
+func (t *loginThrottle) blocked(email, ip string) (time.Duration, bool) {
+	accountWait, accountBlocked := t.accounts.Blocked(strings.ToLower(email))
+	ipWait, ipBlocked := t.ips.Blocked(ip)
+
+	return max(accountWait, ipWait), accountBlocked || ipBlocked
+}
+
+func (t *loginThrottle) fail(email, ip string) {
+	t.accounts.Fail(strings.ToLower(email))
+	t.ips.Fail(ip)
+}
+
+// succeed clears the account's failures. The IP's are left alone so an
+// attacker cannot reset their budget by logging into an account they own.
+func (t *loginThrottle) succeed(email string) {
+	t.accounts.Reset(strings.ToLower(email))
+}
+
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return fmt.Sprint(int(math.Ceil(retryAfter.Seconds())))
+}
+
+func respondWithTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	respondWithError(w, http.StatusTooManyRequests, "too many failed login attempts", nil)
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -1,64 +1,395 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/billing"
+	"github.com/w0/chirpy/internal/contentfilter"
 	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/mailer"
+	"github.com/w0/chirpy/internal/oidc"
+	"github.com/w0/chirpy/internal/webauthn"
+	"github.com/w0/chirpy/internal/webhook"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	secret         string
-	polkaKey       string
+	fileserverHits       atomic.Int32
+	db                   *sql.DB
+	dbQueries            *database.Queries
+	platform             string
+	secret               string
+	billingProviders     map[string]billing.Provider
+	billingPolicy        billing.Policy
+	webhookSender        *webhook.Sender
+	jwtIssuer            string
+	jwtAudience          string
+	jwtLeeway            time.Duration
+	loginThrottle        *loginThrottle
+	mailer               mailer.Mailer
+	baseURL              string
+	requireVerifiedEmail bool
+	deletionGracePeriod  time.Duration
+	exportDir            string
+	passwordPolicy       auth.PasswordPolicy
+	oidcProviders        map[string]*oidc.Provider
+	webauthn             webauthn.Config
+	// contentFilterRules come from config, and are applied along with the
+	// rules stored in the database.
+	contentFilterRules []contentfilter.Rule
+	contentFilter      *contentfilter.Filter
 }
 
 func main() {
+	createAdmin := flag.String("create-admin", "", "give `email` the admin role and exit, creating the account with a password read from stdin if needed")
+	flag.Parse()
+
 	godotenv.Load()
 	dbURL := os.Getenv("DB_URL")
 	secret := os.Getenv("SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
+
 	db, err := sql.Open("postgres", dbURL)
 
 	if err != nil {
 		log.Fatal("Error opening database connection ", err)
 	}
 
+	jwtIssuer := os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = auth.DefaultIssuer
+	}
+
+	var jwtLeeway time.Duration
+	if v := os.Getenv("JWT_LEEWAY"); v != "" {
+		jwtLeeway, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid JWT_LEEWAY ", err)
+		}
+	}
+
+	var adminEmails []string
+	if v := os.Getenv("ADMIN_EMAILS"); v != "" {
+		adminEmails = strings.Split(v, ",")
+	}
+
+	platform := os.Getenv("PLATFORM")
+
+	// Mail carries reset and verification links, which are as good as a
+	// password, so it is only ever written to the log in development.
+	var mail mailer.Mailer
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		mail = mailer.NewSMTPMailer(
+			smtpHost,
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	} else if platform == "dev" {
+		mail = mailer.LogMailer{}
+	} else {
+		log.Fatal("SMTP_HOST must be set unless PLATFORM is dev")
+	}
+
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	deletionGracePeriod := 30 * 24 * time.Hour
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE"); v != "" {
+		deletionGracePeriod, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid ACCOUNT_DELETION_GRACE ", err)
+		}
+	}
+
+	argon2Params := auth.DefaultArgon2Params
+	if v := os.Getenv("ARGON2_MEMORY_KIB"); v != "" {
+		memory, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatal("Invalid ARGON2_MEMORY_KIB ", err)
+		}
+		argon2Params.Memory = uint32(memory)
+	}
+	if v := os.Getenv("ARGON2_ITERATIONS"); v != "" {
+		iterations, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatal("Invalid ARGON2_ITERATIONS ", err)
+		}
+		argon2Params.Iterations = uint32(iterations)
+	}
+
+	auth.SetPasswordHashers(
+		auth.Argon2idHasher{Params: argon2Params},
+		auth.BcryptHasher{Cost: bcrypt.DefaultCost},
+	)
+
+	passwordPolicy := auth.DefaultPasswordPolicy
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		passwordPolicy.MinLength, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Invalid PASSWORD_MIN_LENGTH ", err)
+		}
+	}
+	if v := os.Getenv("PASSWORD_MIN_CLASSES"); v != "" {
+		passwordPolicy.MinClasses, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Invalid PASSWORD_MIN_CLASSES ", err)
+		}
+	}
+	if v := os.Getenv("BREACHED_PASSWORDS_FILE"); v != "" {
+		f, err := os.Open(v)
+		if err != nil {
+			log.Fatal("Error opening BREACHED_PASSWORDS_FILE ", err)
+		}
+		passwordPolicy.Breached, err = auth.LoadBreachedPasswords(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("Error loading BREACHED_PASSWORDS_FILE ", err)
+		}
+		log.Printf("Loaded %d breached password hashes", passwordPolicy.Breached.Len())
+	}
+
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = filepath.Join(os.TempDir(), "chirpy-exports")
+	}
+
+	err = os.MkdirAll(exportDir, 0o700)
+	if err != nil {
+		log.Fatal("Error creating export directory ", err)
+	}
+
+	// OIDC_PROVIDERS names the providers; each is then configured with
+	// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET.
+	oidcProviders := map[string]*oidc.Provider{}
+	if v := os.Getenv("OIDC_PROVIDERS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			env := "OIDC_" + strings.ToUpper(name) + "_"
+
+			issuer := os.Getenv(env + "ISSUER")
+			clientID := os.Getenv(env + "CLIENT_ID")
+			if issuer == "" || clientID == "" {
+				log.Fatalf("%sISSUER and %sCLIENT_ID must be set", env, env)
+			}
+
+			oidcProviders[name] = oidc.NewProvider(oidc.Config{
+				Name:         name,
+				Issuer:       issuer,
+				ClientID:     clientID,
+				ClientSecret: os.Getenv(env + "CLIENT_SECRET"),
+				RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/api/auth/" + name + "/callback",
+				Scopes:       []string{"email", "profile"},
+			})
+		}
+	}
+
+	// Passkeys are bound to a domain, which defaults to the one in
+	// APP_BASE_URL.
+	webauthnOrigins := []string{strings.TrimSuffix(baseURL, "/")}
+	if v := os.Getenv("WEBAUTHN_ORIGINS"); v != "" {
+		webauthnOrigins = strings.Split(v, ",")
+	}
+
+	webauthnRPID := os.Getenv("WEBAUTHN_RP_ID")
+	if webauthnRPID == "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			log.Fatal("Invalid APP_BASE_URL ", err)
+		}
+		webauthnRPID = u.Hostname()
+	}
+
+	// Several secrets may be active at once while a provider rotates them.
+	polka := &billing.Polka{APIKey: os.Getenv("POLKA_KEY")}
+	if v := os.Getenv("POLKA_WEBHOOK_SECRETS"); v != "" {
+		tolerance := 5 * time.Minute
+		if t := os.Getenv("POLKA_WEBHOOK_TOLERANCE"); t != "" {
+			tolerance, err = time.ParseDuration(t)
+			if err != nil {
+				log.Fatal("Invalid POLKA_WEBHOOK_TOLERANCE ", err)
+			}
+		}
+		polka.Verifier = webhook.NewVerifier(strings.Split(v, ","), tolerance)
+	}
+
+	billingProviders := map[string]billing.Provider{polka.Name(): polka}
+
+	if v := os.Getenv("STRIPE_WEBHOOK_SECRETS"); v != "" {
+		stripe := billing.NewStripe(strings.Split(v, ","), 5*time.Minute)
+		if len(stripe.Secrets) == 0 {
+			log.Fatal("STRIPE_WEBHOOK_SECRETS contains no secrets")
+		}
+		billingProviders[stripe.Name()] = stripe
+	}
+
+	billingPolicy := billing.DefaultPolicy
+	if v := os.Getenv("SUBSCRIPTION_GRACE_PERIOD"); v != "" {
+		billingPolicy.GracePeriod, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid SUBSCRIPTION_GRACE_PERIOD ", err)
+		}
+	}
+
+	// Allowing private targets is for local development, where the webhook
+	// receiver is usually on the same machine.
+	webhookSender := webhook.NewSender(webhookSendTimeout, os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS") == "true")
+
+	contentFilterRules := contentfilter.DefaultRules
+	if v := os.Getenv("CONTENT_FILTER_FILE"); v != "" {
+		f, err := os.Open(v)
+		if err != nil {
+			log.Fatal("Error opening CONTENT_FILTER_FILE ", err)
+		}
+		contentFilterRules, err = contentfilter.ParseRules(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("Error loading CONTENT_FILTER_FILE ", err)
+		}
+	}
+
+	contentFilter, err := contentfilter.New(contentFilterRules)
+	if err != nil {
+		log.Fatal("Error building content filter ", err)
+	}
+
 	httpPort := ":8080"
 	serveDir := "."
 
 	apiCfg := apiConfig{
-		dbQueries: database.New(db),
-		platform:  os.Getenv("PLATFORM"),
-		secret:    secret,
-		polkaKey:  polkaKey,
+		db:                   db,
+		dbQueries:            database.New(db),
+		platform:             platform,
+		secret:               secret,
+		billingProviders:     billingProviders,
+		billingPolicy:        billingPolicy,
+		webhookSender:        webhookSender,
+		jwtIssuer:            jwtIssuer,
+		jwtAudience:          os.Getenv("JWT_AUDIENCE"),
+		jwtLeeway:            jwtLeeway,
+		loginThrottle:        newLoginThrottle(),
+		mailer:               mail,
+		baseURL:              strings.TrimSuffix(baseURL, "/"),
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		deletionGracePeriod:  deletionGracePeriod,
+		exportDir:            exportDir,
+		passwordPolicy:       passwordPolicy,
+		oidcProviders:        oidcProviders,
+		webauthn: webauthn.Config{
+			RPID:                    webauthnRPID,
+			RPName:                  "Chirpy",
+			Origins:                 webauthnOrigins,
+			RequireUserVerification: true,
+		},
+		contentFilterRules: contentFilterRules,
+		contentFilter:      contentFilter,
 	}
 
+	if *createAdmin != "" {
+		dbUser, err := apiCfg.createAdmin(context.Background(), *createAdmin, os.Stdin)
+		if err != nil {
+			log.Fatal("Error creating admin ", err)
+		}
+		log.Printf("%s is now an admin", dbUser.Email)
+		return
+	}
+
+	err = apiCfg.promoteAdmins(context.Background(), adminEmails)
+	if err != nil {
+		log.Fatal("Error promoting ADMIN_EMAILS ", err)
+	}
+
+	apiCfg.startJobs(context.Background())
+
 	mux := http.NewServeMux()
 
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(serveDir)))
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(appHandler))
 
 	mux.HandleFunc("GET /api/healthz", handlerHealthz)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerMetrics))
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerResetMetrics)
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerSetUserRole))
+	mux.Handle("POST /admin/users/{userID}/suspension", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerSuspendUser))
+	mux.Handle("DELETE /admin/users/{userID}/suspension", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerLiftSuspension))
+	mux.Handle("POST /admin/users/{userID}/shadow-ban", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerShadowBanUser))
+	mux.Handle("DELETE /admin/users/{userID}/shadow-ban", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerLiftShadowBan))
+	mux.Handle("GET /admin/reports", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerGetReports))
+	mux.Handle("POST /admin/reports/{reportID}/resolve", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerResolveReport))
+	mux.Handle("GET /admin/filter/rules", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetContentFilterRules))
+	mux.Handle("POST /admin/filter/rules", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerNewContentFilterRule))
+	mux.Handle("PUT /admin/filter/rules/{ruleID}", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerUpdateContentFilterRule))
+	mux.Handle("DELETE /admin/filter/rules/{ruleID}", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerDeleteContentFilterRule))
+	mux.Handle("GET /admin/audit", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetModerationActions))
+	mux.Handle("GET /admin/webhooks/events", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetWebhookEvents))
+	mux.Handle("POST /admin/webhooks/events/{eventID}/replay", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerReplayWebhookEvent))
 	mux.HandleFunc("POST /api/users", apiCfg.handlerNewUser)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerNewChirp)
+	mux.Handle("POST /api/chirps", apiCfg.middlewareRequireScope(auth.ScopeChirpsWrite, apiCfg.handlerNewChirp))
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
+	mux.Handle("PUT /api/chirps/{chirpID}", apiCfg.middlewareRequireScope(auth.ScopeChirpsWrite, apiCfg.handlerUpdateChirp))
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
+	mux.HandleFunc("POST /api/email/verify", apiCfg.handlerVerifyEmail)
+	mux.Handle("POST /api/email/verify/resend", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerResendVerification))
+	mux.HandleFunc("GET /api/auth/{provider}/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", apiCfg.handlerOIDCCallback)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthDecision)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.Handle("POST /api/oauth/clients", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerNewOAuthClient))
+	mux.Handle("GET /api/oauth/clients", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetOAuthClients))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDeleteOAuthClient))
+	mux.Handle("GET /api/users/me/apps", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetOAuthGrants))
+	mux.Handle("DELETE /api/users/me/apps/{clientID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerRevokeOAuthGrant))
+	mux.HandleFunc("POST /api/login/passkey/begin", apiCfg.handlerBeginPasskeyLogin)
+	mux.HandleFunc("POST /api/login/passkey/finish", apiCfg.handlerFinishPasskeyLogin)
+	mux.Handle("POST /api/users/me/passkeys/begin", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerBeginPasskeyRegistration))
+	mux.Handle("POST /api/users/me/passkeys", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerFinishPasskeyRegistration))
+	mux.Handle("GET /api/users/me/passkeys", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetPasskeys))
+	mux.Handle("DELETE /api/users/me/passkeys/{passkeyID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDeletePasskey))
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefreshJWT)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevokeRefreshToken)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerAddSub)
+	mux.Handle("PUT /api/users", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerUpdateUser))
+	mux.Handle("PATCH /api/users/me", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerPatchUser))
+	mux.Handle("DELETE /api/users/me", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDeleteUser))
+	mux.Handle("GET /api/users/me/export", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerExportUser))
+	mux.Handle("GET /api/users/me/exports/{exportID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetDataExport))
+	mux.Handle("GET /api/users/me/exports/{exportID}/download", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDownloadDataExport))
+	mux.Handle("POST /api/reports", apiCfg.middlewareRequireScope(auth.ScopeChirpsWrite, apiCfg.handlerNewReport))
+	mux.Handle("DELETE /api/chirps/{chirpID}", apiCfg.middlewareRequireScope(auth.ScopeChirpsDelete, apiCfg.handlerDeleteChirp))
+	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
+	mux.HandleFunc("POST /api/billing/{provider}/webhooks", apiCfg.handlerBillingWebhook)
+	mux.Handle("POST /api/webhooks", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerNewWebhookEndpoint))
+	mux.Handle("GET /api/webhooks", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetWebhookEndpoints))
+	mux.Handle("DELETE /api/webhooks/{webhookID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDeleteWebhookEndpoint))
+	mux.Handle("GET /api/webhooks/{webhookID}/deliveries", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetWebhookDeliveries))
+	mux.Handle("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/retry", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerRetryWebhookDelivery))
+	mux.Handle("POST /api/tokens", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerNewPersonalAccessToken))
+	mux.Handle("GET /api/tokens", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerGetPersonalAccessTokens))
+	mux.Handle("DELETE /api/tokens/{tokenID}", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerRevokePersonalAccessToken))
+	mux.Handle("POST /api/users/me/totp", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerEnrollTOTP))
+	mux.Handle("POST /api/users/me/totp/confirm", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerConfirmTOTP))
+	mux.Handle("DELETE /api/users/me/totp", apiCfg.middlewareRequireScope(auth.ScopeProfileWrite, apiCfg.handlerDisableTOTP))
 
 	server := http.Server{
 		Handler: mux,
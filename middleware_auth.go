@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// errAuthUnavailable marks failures to look up a credential, as opposed to
+// the credential itself being bad.
+var errAuthUnavailable = errors.New("authentication unavailable")
+
+// principal is the authenticated caller of a request, as established by
+// middlewareRequireScope.
+type principal struct {
+	UserID uuid.UUID
+	Scopes []string
+	Role   string
+	// PersonalAccessTokenID is set when the caller used a personal access
+	// token instead of a JWT.
+	PersonalAccessTokenID uuid.NullUUID
+	// ClientID is set when the caller is a third party app acting on the
+	// user's behalf through OAuth.
+	ClientID uuid.NullUUID
+}
+
+func principalFromContext(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey).(principal)
+	return p
+}
+
+func (cfg *apiConfig) authenticate(req *http.Request) (principal, error) {
+	bearerToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if auth.IsPersonalAccessToken(bearerToken) {
+		return cfg.authenticatePersonalAccessToken(req.Context(), bearerToken)
+	}
+
+	claims, err := auth.ParseJWT(bearerToken, cfg.secret, cfg.jwtOptions()...)
+	if err != nil {
+		return principal{}, err
+	}
+
+	dbUser, err := cfg.activeUser(req.Context(), claims.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+
+	// The role is read from the account rather than the token, so a
+	// demotion takes effect at once. Apps never act with a staff role.
+	role := dbUser.Role
+	if claims.ClientID.Valid {
+		role = auth.RoleUser
+	}
+
+	// Access tokens issued to an app die with its grant, so revoking the
+	// app from the account takes effect before they expire.
+	if claims.ClientID.Valid {
+		granted, err := cfg.dbQueries.HasOAuthGrant(req.Context(), database.HasOAuthGrantParams{
+			UserID:   claims.UserID,
+			ClientID: claims.ClientID,
+		})
+		if err != nil {
+			return principal{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+		}
+		if !granted {
+			return principal{}, auth.ErrRevoked
+		}
+	}
+
+	return principal{
+		UserID:   claims.UserID,
+		Scopes:   claims.Scopes,
+		Role:     role,
+		ClientID: claims.ClientID,
+	}, nil
+}
+
+// activeUser loads the owner of a credential, failing with a
+// *moderation.Restriction if they are suspended or banned. Tokens are
+// checked on every request, so a suspension takes effect at once.
+func (cfg *apiConfig) activeUser(ctx context.Context, userID uuid.UUID) (database.User, error) {
+	dbUser, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, auth.ErrUnknownToken
+	}
+	if err != nil {
+		return database.User{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+	}
+
+	err = accountFromDB(dbUser).Check(time.Now())
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return dbUser, nil
+}
+
+func (cfg *apiConfig) authenticatePersonalAccessToken(ctx context.Context, token string) (principal, error) {
+	dbToken, err := cfg.dbQueries.GetPersonalAccessTokenByHash(ctx, auth.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return principal{}, auth.ErrUnknownToken
+	}
+	if err != nil {
+		return principal{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+	}
+
+	if dbToken.RevokedAt.Valid {
+		return principal{}, auth.ErrRevoked
+	}
+
+	if dbToken.ExpiresAt.Valid && dbToken.ExpiresAt.Time.Before(time.Now()) {
+		return principal{}, auth.ErrExpired
+	}
+
+	// Personal access tokens live too long to carry a role, so it is read
+	// fresh on every use.
+	dbUser, err := cfg.activeUser(ctx, dbToken.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+
+	// Only a use that is let through counts towards last_used_at.
+	err = cfg.dbQueries.TouchPersonalAccessToken(ctx, dbToken.ID)
+	if err != nil {
+		return principal{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+	}
+
+	return principal{
+		UserID:                dbToken.UserID,
+		Scopes:                auth.ParseScopes(dbToken.Scope),
+		Role:                  dbUser.Role,
+		PersonalAccessTokenID: uuid.NullUUID{UUID: dbToken.ID, Valid: true},
+	}, nil
+}
+
+// optionalViewer identifies the caller of a public route, so they can be
+// shown their own content. Anonymous callers, and ones whose token does not
+// check out, get a null ID rather than an error.
+func (cfg *apiConfig) optionalViewer(req *http.Request) uuid.NullUUID {
+	if req.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}
+	}
+
+	p, err := cfg.authenticate(req)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{UUID: p.UserID, Valid: true}
+}
+
+// middlewareRequireScope rejects requests without a valid access token
+// carrying scope, and hands the caller to next via the request context.
+func (cfg *apiConfig) middlewareRequireScope(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, err := cfg.authenticate(req)
+		if errors.Is(err, errAuthUnavailable) {
+			respondWithError(w, http.StatusInternalServerError, "failed to authenticate", err)
+			return
+		}
+		var restriction *moderation.Restriction
+		if errors.As(err, &restriction) {
+			respondWithAccountRestricted(w, restriction)
+			return
+		}
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+
+		if !auth.HasScope(p.Scopes, scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			respondWithError(w, http.StatusForbidden, "token missing scope "+scope, nil)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), principalKey, p)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// middlewareRequireRole is middlewareRequireScope for routes reserved for
+// role or above. The token must also carry the role's scope, so a narrowed
+// token cannot be used with the user's full privileges.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return cfg.middlewareRequireScope(auth.RoleScope(role), func(w http.ResponseWriter, req *http.Request) {
+		p := principalFromContext(req.Context())
+
+		if !auth.HasRole(p.Role, role) {
+			respondWithError(w, http.StatusForbidden, "requires role "+role, nil)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
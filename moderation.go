@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/auth"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/moderation"
+)
+
+const (
+	reportStatusOpen      = "open"
+	reportStatusDismissed = "dismissed"
+	reportStatusActioned  = "actioned"
+)
+
+// errStaffTarget is returned when a decision would restrict a moderator
+// or admin. Their role has to be removed first.
+var errStaffTarget = errors.New("cannot restrict staff accounts")
+
+func accountFromDB(u database.User) moderation.Account {
+	a := moderation.Account{
+		SuspensionReason: u.SuspensionReason.String,
+		BanReason:        u.BanReason.String,
+		ShadowBanned:     u.ShadowBannedAt.Valid,
+	}
+
+	if u.SuspendedUntil.Valid {
+		a.SuspendedUntil = u.SuspendedUntil.Time
+	}
+	if u.BannedAt.Valid {
+		a.BannedAt = u.BannedAt.Time
+	}
+
+	return a
+}
+
+// restrictAccount applies an account level decision to userID using qtx.
+// It returns the updated user and, for suspensions, when it ends.
+func restrictAccount(ctx context.Context, qtx *database.Queries, userID uuid.UUID, d moderation.Decision) (database.User, sql.NullTime, error) {
+	dbUser, err := qtx.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, sql.NullTime{}, err
+	}
+
+	var until sql.NullTime
+	reason := sql.NullString{String: d.Reason, Valid: true}
+
+	switch d.Action {
+	case moderation.ActionLiftSuspension:
+		dbUser, err = qtx.LiftSuspension(ctx, userID)
+		return dbUser, until, err
+	case moderation.ActionLiftShadowBan:
+		dbUser, err = qtx.LiftShadowBan(ctx, userID)
+		return dbUser, until, err
+	}
+
+	if auth.HasRole(dbUser.Role, auth.RoleModerator) {
+		return database.User{}, until, errStaffTarget
+	}
+
+	switch d.Action {
+	case moderation.ActionSuspend:
+		until = nullTime(time.Now().Add(d.Duration))
+		dbUser, err = qtx.SuspendUser(ctx, database.SuspendUserParams{
+			SuspendedUntil:   until,
+			SuspensionReason: reason,
+			ID:               userID,
+		})
+	case moderation.ActionBan:
+		dbUser, err = qtx.BanUser(ctx, database.BanUserParams{
+			BanReason: reason,
+			ID:        userID,
+		})
+	case moderation.ActionShadowBan:
+		// The user is not told, so their sessions are left alone.
+		dbUser, err = qtx.ShadowBanUser(ctx, userID)
+		return dbUser, until, err
+	default:
+		return database.User{}, until, moderation.ErrUnknownAction
+	}
+	if err != nil {
+		return database.User{}, until, err
+	}
+
+	// Access tokens are refused by authenticate from now on; revoking
+	// refresh tokens means the user has to sign in again afterwards.
+	err = qtx.RevokeUserRefreshTokens(ctx, userID)
+	return dbUser, until, err
+}
+
+// moderateUser applies d to userID directly, rather than in answer to a
+// report, and audits it.
+func (cfg *apiConfig) moderateUser(ctx context.Context, moderatorID, userID uuid.UUID, d moderation.Decision) (database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	dbUser, until, err := restrictAccount(ctx, qtx, userID, d)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = qtx.CreateModerationAction(ctx, database.CreateModerationActionParams{
+		ModeratorID: uuid.NullUUID{UUID: moderatorID, Valid: true},
+		Action:      d.Action,
+		UserID:      uuid.NullUUID{UUID: userID, Valid: true},
+		Reason:      d.Reason,
+		ExpiresAt:   until,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return dbUser, tx.Commit()
+}
+
+// resolveReport carries out a moderator's decision on report, closes every
+// open report it settles and writes the audit log entry, all in one
+// transaction.
+func (cfg *apiConfig) resolveReport(ctx context.Context, moderatorID uuid.UUID, report database.Report, d moderation.Decision) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	moderator := uuid.NullUUID{UUID: moderatorID, Valid: true}
+	resolution := sql.NullString{String: d.Action, Valid: true}
+	action := database.CreateModerationActionParams{
+		ModeratorID: moderator,
+		Action:      d.Action,
+		ReportID:    uuid.NullUUID{UUID: report.ID, Valid: true},
+		UserID:      uuid.NullUUID{UUID: report.UserID, Valid: true},
+		ChirpID:     report.ChirpID,
+		Reason:      d.Reason,
+	}
+
+	var n int64
+	switch d.Action {
+	case moderation.ActionDismiss:
+		n, err = qtx.ResolveReport(ctx, database.ResolveReportParams{
+			Status:     reportStatusDismissed,
+			ResolvedBy: moderator,
+			Resolution: resolution,
+			ID:         report.ID,
+		})
+
+	case moderation.ActionHideChirp:
+		_, err = qtx.HideChirp(ctx, database.HideChirpParams{
+			HiddenReason: sql.NullString{String: d.Reason, Valid: true},
+			ID:           report.ChirpID.UUID,
+		})
+		if err != nil {
+			return err
+		}
+
+		n, err = qtx.ResolveOpenReports(ctx, database.ResolveOpenReportsParams{
+			Status:     reportStatusActioned,
+			ResolvedBy: moderator,
+			Resolution: resolution,
+			UserID:     report.UserID,
+			ChirpID:    report.ChirpID,
+		})
+
+	case moderation.ActionSuspend, moderation.ActionBan, moderation.ActionShadowBan:
+		_, action.ExpiresAt, err = restrictAccount(ctx, qtx, report.UserID, d)
+		if err != nil {
+			return err
+		}
+
+		// Every open report about the account is settled, whichever chirp
+		// it was about.
+		n, err = qtx.ResolveOpenReports(ctx, database.ResolveOpenReportsParams{
+			Status:     reportStatusActioned,
+			ResolvedBy: moderator,
+			Resolution: resolution,
+			UserID:     report.UserID,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Someone else resolved the report first.
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = qtx.CreateModerationAction(ctx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,23 @@
+-- name: UpsertBillingCustomer :exec
+INSERT INTO billing_customers (id, created_at, updated_at, provider, customer_id, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (provider, customer_id) DO UPDATE
+SET user_id = EXCLUDED.user_id,
+    updated_at = NOW();
+
+-- name: GetBillingCustomer :one
+SELECT * FROM billing_customers
+WHERE provider = $1
+AND customer_id = $2;
+
+-- name: ListBillingCustomersByUser :many
+SELECT * FROM billing_customers
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -1,22 +1,84 @@
 -- name: NewChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, published_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
-    $1,
-    $2
+    sqlc.arg(body),
+    sqlc.arg(user_id),
+    COALESCE(sqlc.narg(published_at)::timestamp, NOW())
 )
 RETURNING *;
 
 -- name: GetChirps :many
-SELECT * FROM chirps
-    ORDER BY created_at ASC;
+SELECT chirps.* FROM chirps
+    JOIN users ON users.id = chirps.user_id
+    WHERE chirps.published_at <= NOW()
+    AND (
+        chirps.user_id = sqlc.narg(viewer_id)
+        OR (chirps.hidden_at IS NULL AND users.shadow_banned_at IS NULL)
+    )
+    ORDER BY chirps.published_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
     WHERE id = $1;
 
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
 -- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE id = $1;
+
+-- name: CountChirpsByUser :one
+SELECT COUNT(*) FROM chirps
+    WHERE user_id = $1;
+
+-- name: GetChirpsByUserPage :many
+SELECT * FROM chirps
+    WHERE user_id = sqlc.arg(user_id)
+    AND (created_at, id) > (sqlc.arg(after_created_at)::timestamp, sqlc.arg(after_id)::uuid)
+    ORDER BY created_at ASC, id ASC
+    LIMIT sqlc.arg(page_size);
+
+-- name: GetChirpRateWindow :one
+SELECT COUNT(*) AS sent, COALESCE(MIN(created_at), NOW())::timestamp AS oldest FROM chirps
+    WHERE user_id = $1
+    AND created_at > $2;
+
+-- name: CountScheduledChirpsByUser :one
+SELECT COUNT(*) FROM chirps
+    WHERE user_id = $1
+    AND published_at > NOW();
+
+-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW(),
+    hidden_reason = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: MarkChirpAnnounced :execrows
+UPDATE chirps
+SET announced_at = NOW()
+WHERE id = $1
+AND announced_at IS NULL;
+
+-- name: ClaimUnannouncedChirps :many
+UPDATE chirps
+SET announced_at = NOW()
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE announced_at IS NULL
+    AND published_at <= NOW()
+    ORDER BY published_at ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
@@ -0,0 +1,30 @@
+-- name: CreateContentFilterRule :one
+INSERT INTO content_filter_rules (id, created_at, updated_at, pattern, action, whole_word, leet)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: ListContentFilterRules :many
+SELECT * FROM content_filter_rules
+ORDER BY created_at ASC;
+
+-- name: UpdateContentFilterRule :one
+UPDATE content_filter_rules
+SET pattern = $1,
+    action = $2,
+    whole_word = $3,
+    leet = $4,
+    updated_at = NOW()
+WHERE id = $5
+RETURNING *;
+
+-- name: DeleteContentFilterRule :execrows
+DELETE FROM content_filter_rules
+WHERE id = $1;
@@ -0,0 +1,70 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, user_id, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'pending'
+)
+ON CONFLICT (user_id) WHERE status IN ('pending', 'running') DO NOTHING
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+    WHERE id = $1
+    AND user_id = $2;
+
+-- name: ClaimPendingDataExport :one
+UPDATE data_exports
+SET status = 'running',
+    updated_at = NOW()
+WHERE id = (
+    SELECT id FROM data_exports
+    WHERE status = 'pending'
+    OR (status = 'running' AND updated_at < sqlc.arg(stale_before)::timestamp)
+    ORDER BY created_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteDataExport :execrows
+UPDATE data_exports
+SET status = 'complete',
+    file_path = $1,
+    completed_at = NOW(),
+    expires_at = $2,
+    updated_at = NOW()
+WHERE id = $3;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed',
+    error = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: DeleteExpiredDataExports :many
+DELETE FROM data_exports
+WHERE expires_at IS NOT NULL
+AND expires_at <= NOW()
+RETURNING *;
+
+-- name: DeletePurgeableDataExports :many
+DELETE FROM data_exports
+USING users
+WHERE data_exports.user_id = users.id
+AND users.deletion_scheduled_at IS NOT NULL
+AND users.deletion_scheduled_at <= NOW()
+RETURNING data_exports.*;
+
+-- name: GetReusableDataExport :one
+SELECT * FROM data_exports
+    WHERE user_id = $1
+    AND (
+        status IN ('pending', 'running')
+        OR (status = 'complete' AND completed_at > $2)
+    )
+    ORDER BY created_at DESC
+    LIMIT 1;
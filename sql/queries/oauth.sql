@@ -0,0 +1,81 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, secret_hash, redirect_uris, scope)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at ASC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1
+AND owner_id = $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scope, code_challenge, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ConsumeOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOAuthAuthorizationCodes :exec
+DELETE FROM oauth_authorization_codes
+WHERE expires_at <= NOW();
+
+-- name: ListOAuthGrants :many
+SELECT oauth_clients.id, oauth_clients.name,
+    MIN(refresh_tokens.created_at)::timestamp AS granted_at,
+    MAX(refresh_tokens.updated_at)::timestamp AS last_used_at
+FROM refresh_tokens
+JOIN oauth_clients ON oauth_clients.id = refresh_tokens.client_id
+WHERE refresh_tokens.user_id = $1
+AND refresh_tokens.revoked_at IS NULL
+AND refresh_tokens.expires_at > NOW()
+GROUP BY oauth_clients.id, oauth_clients.name
+ORDER BY granted_at ASC;
+
+-- name: HasOAuthGrant :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE user_id = $1
+    AND client_id = $2
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+);
+
+-- name: RevokeOAuthGrant :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND client_id = $2
+AND revoked_at IS NULL;
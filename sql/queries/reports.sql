@@ -0,0 +1,46 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, created_at, updated_at, reporter_id, user_id, chirp_id, reason, details, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    'open'
+)
+ON CONFLICT DO NOTHING
+RETURNING *;
+
+-- name: GetReport :one
+SELECT * FROM reports
+WHERE id = $1;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = sqlc.arg(status)
+ORDER BY created_at ASC
+LIMIT sqlc.arg(page_size);
+
+-- name: ResolveReport :execrows
+UPDATE reports
+SET status = $1,
+    resolved_by = $2,
+    resolution = $3,
+    resolved_at = NOW(),
+    updated_at = NOW()
+WHERE id = $4
+AND status = 'open';
+
+-- name: ResolveOpenReports :execrows
+UPDATE reports
+SET status = sqlc.arg(status),
+    resolved_by = sqlc.arg(resolved_by),
+    resolution = sqlc.arg(resolution),
+    resolved_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+AND (sqlc.narg(chirp_id)::uuid IS NULL OR chirp_id = sqlc.narg(chirp_id))
+AND status = 'open';
@@ -0,0 +1,44 @@
+-- name: GetSubscriptionByUserForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: GetSubscriptionByUser :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_end, grace_until, canceled_at, ended_at, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    grace_until = EXCLUDED.grace_until,
+    canceled_at = EXCLUDED.canceled_at,
+    ended_at = EXCLUDED.ended_at,
+    expires_at = EXCLUDED.expires_at,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ExpireSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired',
+    ended_at = expires_at,
+    expires_at = NULL,
+    updated_at = NOW()
+WHERE status <> 'expired'
+AND expires_at <= NOW()
+RETURNING *;
@@ -20,15 +20,129 @@ WHERE email = $1;
 SELECT * FROM users
 WHERE id = $1;
 
--- name: UpdateUser :one
+-- name: SyncChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+    AND subscriptions.status <> 'expired'
+    AND subscriptions.expires_at > NOW()
+)
+WHERE id = $1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1,
+    totp_enabled = FALSE,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled = TRUE,
+    totp_last_step = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL,
+    totp_enabled = FALSE,
+    totp_last_step = 0,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2
+AND totp_last_step < $1;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified = TRUE,
+    updated_at = NOW()
+WHERE id = $1
+AND email = $2;
+
+-- name: ChangeEmail :one
 UPDATE users
 SET email = $1,
-    hashed_password = $2,
+    email_verified = TRUE,
     updated_at = NOW()
-WHERE id = $3
+WHERE id = $2
 RETURNING *;
 
--- name: UpdateChirpySub :exec
+-- name: ScheduleUserDeletion :exec
 UPDATE users
-SET is_chirpy_red = $1
+SET deletion_scheduled_at = $1,
+    updated_at = NOW()
 WHERE id = $2;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deletion_scheduled_at IS NOT NULL
+AND deletion_scheduled_at <= NOW();
+
+-- name: UpdateUserRole :one
+UPDATE users
+SET role = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: SetUserRoleByEmail :execrows
+UPDATE users
+SET role = $1,
+    updated_at = NOW()
+WHERE email = $2;
+
+-- name: SuspendUser :one
+UPDATE users
+SET suspended_until = $1,
+    suspension_reason = $2,
+    updated_at = NOW()
+WHERE id = $3
+RETURNING *;
+
+-- name: LiftSuspension :one
+UPDATE users
+SET suspended_until = NULL,
+    suspension_reason = NULL,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: BanUser :one
+UPDATE users
+SET banned_at = NOW(),
+    ban_reason = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: ShadowBanUser :one
+UPDATE users
+SET shadow_banned_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: LiftShadowBan :one
+UPDATE users
+SET shadow_banned_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
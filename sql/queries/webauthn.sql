@@ -0,0 +1,58 @@
+-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, name, credential_id, public_key, sign_count)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetWebauthnCredential :one
+SELECT * FROM webauthn_credentials
+WHERE credential_id = $1;
+
+-- name: ListWebauthnCredentials :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: UpdateWebauthnSignCount :execrows
+UPDATE webauthn_credentials
+SET sign_count = $1,
+    last_used_at = NOW(),
+    updated_at = NOW()
+WHERE id = $2
+AND sign_count = $3;
+
+-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1
+AND user_id = $2;
+
+-- name: CreateWebauthnChallenge :one
+INSERT INTO webauthn_challenges (id, created_at, user_id, purpose, challenge, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: ConsumeWebauthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE id = $1
+AND purpose = $2
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebauthnChallenges :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= NOW();
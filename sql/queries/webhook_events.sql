@@ -0,0 +1,59 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, created_at, updated_at, provider, event_id, event_type, payload, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'processing'
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: RetryWebhookEvent :one
+UPDATE webhook_events
+SET status = 'processing',
+    attempts = attempts + 1,
+    error = NULL,
+    updated_at = NOW()
+WHERE provider = $1
+AND event_id = $2
+AND (status = 'failed' OR (status = 'processing' AND updated_at < sqlc.arg(stale_before)::timestamp))
+RETURNING *;
+
+-- name: RetryWebhookEventByID :one
+UPDATE webhook_events
+SET status = 'processing',
+    attempts = attempts + 1,
+    error = NULL,
+    updated_at = NOW()
+WHERE id = $1
+AND (status = 'failed' OR (status = 'processing' AND updated_at < sqlc.arg(stale_before)::timestamp))
+RETURNING *;
+
+-- name: CompleteWebhookEvent :exec
+UPDATE webhook_events
+SET status = $1,
+    processed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: FailWebhookEvent :exec
+UPDATE webhook_events
+SET status = 'failed',
+    error = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE (sqlc.arg(status)::text = '' OR status = sqlc.arg(status))
+ORDER BY created_at DESC
+LIMIT sqlc.arg(page_size);
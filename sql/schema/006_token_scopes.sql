@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD scope TEXT NOT NULL DEFAULT 'chirps:write chirps:delete profile:write';
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN scope;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE users
+ADD email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE email_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID
+        NOT NULL
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    purpose TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE email_tokens;
+
+ALTER TABLE users
+DROP COLUMN email_verified;
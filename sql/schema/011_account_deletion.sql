@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE users
+ADD deletion_scheduled_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN deletion_scheduled_at;
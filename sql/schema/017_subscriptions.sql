@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID
+        UNIQUE
+        NOT NULL
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    grace_until TIMESTAMP,
+    canceled_at TIMESTAMP,
+    ended_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX subscriptions_expires_at_idx ON subscriptions (expires_at)
+WHERE status <> 'expired';
+
+-- Polka never told us when existing upgrades renew, so give them a fresh
+-- period rather than dropping them.
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_end, expires_at)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'chirpy_red', 'active', NOW() + INTERVAL '30 days', NOW() + INTERVAL '33 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD published_at TIMESTAMP;
+
+UPDATE chirps
+SET published_at = created_at;
+
+ALTER TABLE chirps
+ALTER COLUMN published_at SET NOT NULL;
+
+CREATE INDEX chirps_published_at_idx ON chirps (published_at);
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN published_at;
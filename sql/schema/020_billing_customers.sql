@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE billing_customers (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    user_id UUID
+        NOT NULL
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    UNIQUE (provider, customer_id)
+);
+
+-- +goose Down
+DROP TABLE billing_customers;
@@ -0,0 +1,75 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN hidden_at TIMESTAMP,
+ADD COLUMN hidden_reason TEXT;
+
+ALTER TABLE users
+ADD COLUMN suspended_until TIMESTAMP,
+ADD COLUMN banned_at TIMESTAMP;
+
+CREATE TABLE reports (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    reporter_id UUID
+        NOT NULL
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    -- The reported account, or the author of the reported chirp.
+    user_id UUID
+        NOT NULL
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    chirp_id UUID
+        REFERENCES chirps(id)
+        ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    resolved_by UUID
+        REFERENCES users(id)
+        ON DELETE SET NULL,
+    resolved_at TIMESTAMP,
+    resolution TEXT
+);
+
+-- One open report per reporter and target, so reporting twice does not
+-- push a chirp up the queue.
+CREATE UNIQUE INDEX reports_open_unique_idx
+ON reports (reporter_id, user_id, COALESCE(chirp_id, '00000000-0000-0000-0000-000000000000'))
+WHERE status = 'open';
+
+CREATE INDEX reports_open_idx ON reports (created_at)
+WHERE status = 'open';
+
+CREATE TABLE moderation_actions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    moderator_id UUID
+        REFERENCES users(id)
+        ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    report_id UUID
+        REFERENCES reports(id)
+        ON DELETE SET NULL,
+    -- No foreign keys: the audit log outlives what it refers to.
+    user_id UUID,
+    chirp_id UUID,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX moderation_actions_created_at_idx ON moderation_actions (created_at);
+
+-- +goose Down
+DROP TABLE moderation_actions;
+
+DROP TABLE reports;
+
+ALTER TABLE users
+DROP COLUMN suspended_until,
+DROP COLUMN banned_at;
+
+ALTER TABLE chirps
+DROP COLUMN hidden_at,
+DROP COLUMN hidden_reason;
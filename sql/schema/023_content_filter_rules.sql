@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE content_filter_rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL
+        CHECK (action IN ('mask', 'reject', 'flag')),
+    whole_word BOOLEAN NOT NULL DEFAULT TRUE,
+    leet BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Chirps flagged by the filter are reported by nobody.
+ALTER TABLE reports
+ALTER COLUMN reporter_id DROP NOT NULL;
+
+-- +goose Down
+DELETE FROM reports
+WHERE reporter_id IS NULL;
+
+ALTER TABLE reports
+ALTER COLUMN reporter_id SET NOT NULL;
+
+DROP TABLE content_filter_rules;
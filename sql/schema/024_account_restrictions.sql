@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN suspension_reason TEXT,
+ADD COLUMN ban_reason TEXT,
+ADD COLUMN shadow_banned_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN suspension_reason,
+DROP COLUMN ban_reason,
+DROP COLUMN shadow_banned_at;
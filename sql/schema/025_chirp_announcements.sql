@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN announced_at TIMESTAMP;
+
+-- Every existing chirp had chirp.created sent when it was written,
+-- scheduled or not.
+UPDATE chirps
+SET announced_at = created_at;
+
+CREATE INDEX chirps_unannounced_idx ON chirps (published_at)
+WHERE announced_at IS NULL;
+
+-- +goose Down
+DROP INDEX chirps_unannounced_idx;
+
+ALTER TABLE chirps
+DROP COLUMN announced_at;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Only the newest queued export per user is kept, so the index below can
+-- be built.
+UPDATE data_exports
+SET status = 'failed',
+    error = 'superseded by a newer export',
+    updated_at = NOW()
+WHERE status IN ('pending', 'running')
+AND id NOT IN (
+    SELECT DISTINCT ON (user_id) id FROM data_exports
+    WHERE status IN ('pending', 'running')
+    ORDER BY user_id, created_at DESC
+);
+
+CREATE UNIQUE INDEX data_exports_active_user_idx ON data_exports (user_id)
+WHERE status IN ('pending', 'running');
+
+-- +goose Down
+DROP INDEX data_exports_active_user_idx;
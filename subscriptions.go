@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/billing"
+	"github.com/w0/chirpy/internal/database"
+)
+
+type Subscription struct {
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	CurrentPeriodEnd time.Time  `json:"current_period_end"`
+	GraceUntil       *time.Time `json:"grace_until"`
+	CanceledAt       *time.Time `json:"canceled_at"`
+	EndedAt          *time.Time `json:"ended_at"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func subscriptionFromDB(s database.Subscription) Subscription {
+	return Subscription{
+		Plan:             s.Plan,
+		Status:           s.Status,
+		CurrentPeriodEnd: s.CurrentPeriodEnd,
+		GraceUntil:       timeOrNil(s.GraceUntil),
+		CanceledAt:       timeOrNil(s.CanceledAt),
+		EndedAt:          timeOrNil(s.EndedAt),
+		ExpiresAt:        timeOrNil(s.ExpiresAt),
+	}
+}
+
+func billingSubscriptionFromDB(s database.Subscription) billing.Subscription {
+	return billing.Subscription{
+		Plan:             s.Plan,
+		Status:           billing.Status(s.Status),
+		CurrentPeriodEnd: s.CurrentPeriodEnd,
+		GraceUntil:       s.GraceUntil.Time,
+		CanceledAt:       s.CanceledAt.Time,
+		EndedAt:          s.EndedAt.Time,
+		ExpiresAt:        s.ExpiresAt.Time,
+	}
+}
+
+// applySubscriptionEvent moves userID's subscription on by e, and brings
+// is_chirpy_red in line with the result.
+func (cfg *apiConfig) applySubscriptionEvent(ctx context.Context, userID uuid.UUID, e billing.Event) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	current := billing.Subscription{}
+	dbSub, err := qtx.GetSubscriptionByUserForUpdate(ctx, userID)
+	if err == nil {
+		current = billingSubscriptionFromDB(dbSub)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	now := time.Now()
+
+	next, err := cfg.billingPolicy.Apply(current, e, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:           userID,
+		Plan:             next.Plan,
+		Status:           string(next.Status),
+		CurrentPeriodEnd: next.CurrentPeriodEnd,
+		GraceUntil:       nullTime(next.GraceUntil),
+		CanceledAt:       nullTime(next.CanceledAt),
+		EndedAt:          nullTime(next.EndedAt),
+		ExpiresAt:        nullTime(next.ExpiresAt),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = qtx.SyncChirpyRed(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.billingPolicy.Entitled(current, now) && cfg.billingPolicy.Entitled(next, now) {
+		type upgrade struct {
+			UserID uuid.UUID `json:"user_id"`
+			Plan   string    `json:"plan"`
+		}
+
+		err = emitWebhookEvent(ctx, qtx, userID, eventUserUpgraded, upgrade{UserID: userID, Plan: next.Plan})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	expired, err := qtx.ExpireSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range expired {
+		err = qtx.SyncChirpyRed(ctx, item.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if len(expired) > 0 {
+		log.Printf("expired %d subscriptions", len(expired))
+	}
+
+	return nil
+}
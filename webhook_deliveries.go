@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w0/chirpy/internal/database"
+	"github.com/w0/chirpy/internal/webhook"
+)
+
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserUpgraded = "user.upgraded"
+
+	deliveryStatusPending   = "pending"
+	deliveryStatusSucceeded = "succeeded"
+	deliveryStatusDead      = "dead"
+
+	webhookSendTimeout    = 10 * time.Second
+	webhookDeliveryBatch  = 20
+	webhookDeliveryPeriod = 5 * time.Second
+	// webhookDeliveryLease is how long a claimed delivery is left alone
+	// before another worker may pick it up, in case the first one died.
+	// It must outlast a whole batch of timed out sends.
+	webhookDeliveryLease = 5 * time.Minute
+)
+
+// webhookEventTypes lists the events endpoints can subscribe to.
+var webhookEventTypes = []string{
+	eventChirpCreated,
+	eventChirpDeleted,
+	eventUserUpgraded,
+}
+
+type outgoingEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// emitWebhookEvent queues a delivery of eventType to each of userID's
+// endpoints that subscribe to it. q may be bound to a transaction, so the
+// event is only sent if the change it describes is committed.
+func emitWebhookEvent(ctx context.Context, q *database.Queries, userID uuid.UUID, eventType string, data any) error {
+	event := outgoingEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   event.ID,
+		EventType: eventType,
+		Payload:   payload,
+		UserID:    userID,
+	})
+	return err
+}
+
+func (cfg *apiConfig) deliverWebhooks(ctx context.Context) error {
+	due, err := cfg.dbQueries.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+		LeaseUntil: time.Now().Add(webhookDeliveryLease),
+		BatchSize:  webhookDeliveryBatch,
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoints := map[uuid.UUID]database.WebhookEndpoint{}
+
+	for _, delivery := range due {
+		endpoint, ok := endpoints[delivery.EndpointID]
+		if !ok {
+			endpoint, err = cfg.dbQueries.GetWebhookEndpoint(ctx, delivery.EndpointID)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Deleted since it was claimed; its deliveries went with it.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			endpoints[endpoint.ID] = endpoint
+		}
+
+		status, sendErr := cfg.webhookSender.Send(ctx, webhook.Delivery{
+			URL:       endpoint.Url,
+			Secret:    endpoint.Secret,
+			EventID:   delivery.EventID.String(),
+			EventType: delivery.EventType,
+			Payload:   delivery.Payload,
+		})
+
+		err = cfg.recordWebhookDelivery(ctx, delivery, status, sendErr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) recordWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery, status int, sendErr error) error {
+	responseStatus := sql.NullInt32{Int32: int32(status), Valid: status != 0}
+
+	if sendErr == nil {
+		return cfg.dbQueries.CompleteWebhookDelivery(ctx, database.CompleteWebhookDeliveryParams{
+			ResponseStatus: responseStatus,
+			ID:             delivery.ID,
+		})
+	}
+
+	next := deliveryStatusPending
+	wait, ok := webhook.DefaultRetryPolicy.Next(int(delivery.Attempts))
+	if !ok {
+		next = deliveryStatusDead
+		log.Printf("webhook delivery %s dead after %d attempts: %s", delivery.ID, delivery.Attempts, sendErr)
+	}
+
+	return cfg.dbQueries.FailWebhookDelivery(ctx, database.FailWebhookDeliveryParams{
+		Status:         next,
+		NextAttemptAt:  time.Now().Add(wait),
+		ResponseStatus: responseStatus,
+		LastError:      sql.NullString{String: sendErr.Error(), Valid: true},
+		ID:             delivery.ID,
+	})
+}